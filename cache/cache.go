@@ -0,0 +1,356 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/vx416/gox/log"
+)
+
+var (
+	// ErrCacheMiss is returned when the key does not exist in cache
+	ErrCacheMiss = errors.New("cache: key not found")
+	// ErrNotFound is returned by a loader when the source record does not exist,
+	// the result will be negative cached and returned to later readers
+	ErrNotFound = errors.New("cache: record not found")
+	// ErrInvalidTagTTL is returned when a tagged value is set without ttl, tag groups only track expiring keys
+	ErrInvalidTagTTL = errors.New("cache: tagged value requires a positive ttl")
+)
+
+var (
+	// KEYS[1] tag set; ARGV[1] key without prefix, ARGV[2] ttl in ms.
+	// Members are scored by their expiry in redis time, so expired members are pruned on every write
+	// and the tag set lives as long as its longest living member.
+	luaTagAdd = redis.NewScript(`
+redis.replicate_commands()
+local now = redis.call("time")
+local nowMs = tonumber(now[1]) * 1000 + math.floor(tonumber(now[2]) / 1000)
+local ttl = tonumber(ARGV[2])
+redis.call("zremrangebyscore", KEYS[1], "-inf", nowMs)
+redis.call("zadd", KEYS[1], nowMs + ttl, ARGV[1])
+if redis.call("pttl", KEYS[1]) < ttl then
+	redis.call("pexpire", KEYS[1], ttl)
+end
+return 1`)
+	// KEYS[1] tag set; ARGV member and score pairs. Members written again meanwhile have a new score and are kept.
+	luaTagRemove = redis.NewScript(`
+local removed = 0
+for i = 1, #ARGV, 2 do
+	if tonumber(redis.call("zscore", KEYS[1], ARGV[i])) == tonumber(ARGV[i + 1]) then
+		removed = removed + redis.call("zrem", KEYS[1], ARGV[i])
+	end
+end
+return removed`)
+)
+
+// defaultLoadTimeout bound a shared load, it is the lease of the distributed load lock as well
+const defaultLoadTimeout = 10 * time.Second
+
+// negativeValue marks a cached "not found" result, it can not be produced by payload encoding
+var negativeValue = []byte{0x00, 'n', 'i', 'l'}
+
+// Loader load the value from source when cache missed
+type Loader func(ctx context.Context) (interface{}, error)
+
+// Cache cache-aside abstraction based on redis
+type Cache interface {
+	Get(ctx context.Context, key string, dst interface{}) error
+	Set(ctx context.Context, key string, val interface{}, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+	GetOrLoad(ctx context.Context, key string, ttl time.Duration, loader Loader, dst interface{}) error
+	// SetWithTags set value and add the key into every tag group, ttl must be positive
+	SetWithTags(ctx context.Context, key string, val interface{}, ttl time.Duration, tags ...string) error
+	// InvalidateTags delete every key of the tag groups. Every script touches a single key,
+	// so keys and tag groups may live in different slots of a redis cluster.
+	InvalidateTags(ctx context.Context, tags ...string) error
+}
+
+// CacheCfg cache config
+type CacheCfg struct {
+	Prefix            string      `yaml:"prefix"`
+	NegativeTTLSec    int         `yaml:"negative_ttl_sec"`
+	DistributedLoad   bool        `yaml:"distributed_load"` // deduplicate loads across processes via client locker
+	LoadTimeoutMs     int         `yaml:"load_timeout_ms"`  // bound a shared load and its lock lease, default is 10 seconds
+	Compression       Compression `yaml:"compression"`
+	CompressThreshold int         `yaml:"compress_threshold"` // payloads smaller than threshold bytes are not compressed
+	Codec             Codec       `yaml:"-"`                  // default is JSONCodec
+}
+
+// NewCache construct a cache on top of redis client, it returns ErrUnknownCompression if compression is not supported
+func NewCache(client *RedisClient, cfg *CacheCfg) (Cache, error) {
+	return newRedisCache(client, cfg)
+}
+
+func newRedisCache(client *RedisClient, cfg *CacheCfg) (*redisCache, error) {
+	if cfg == nil {
+		cfg = &CacheCfg{}
+	}
+	payload, err := newPayloadCodec(cfg.Codec, cfg.Compression, cfg.CompressThreshold)
+	if err != nil {
+		return nil, err
+	}
+	loadTimeout := time.Duration(cfg.LoadTimeoutMs) * time.Millisecond
+	if loadTimeout <= 0 {
+		loadTimeout = defaultLoadTimeout
+	}
+
+	return &redisCache{
+		client:          client,
+		prefix:          cfg.Prefix,
+		negativeTTL:     time.Duration(cfg.NegativeTTLSec) * time.Second,
+		distributedLoad: cfg.DistributedLoad,
+		loadTimeout:     loadTimeout,
+		payload:         payload,
+	}, nil
+}
+
+type redisCache struct {
+	client          *RedisClient
+	prefix          string
+	negativeTTL     time.Duration
+	distributedLoad bool
+	loadTimeout     time.Duration
+	payload         *payloadCodec
+	group           flightGroup
+}
+
+func (c *redisCache) key(key string) string {
+	if c.prefix == "" {
+		return key
+	}
+	return c.prefix + "." + key
+}
+
+func (c *redisCache) tagKey(tag string) string {
+	return c.key("tags." + tag)
+}
+
+// Get get value from cache, return ErrCacheMiss if key not exist
+func (c *redisCache) Get(ctx context.Context, key string, dst interface{}) error {
+	data, err := c.getBytes(ctx, key)
+	if err != nil {
+		return err
+	}
+	return c.decode(data, dst)
+}
+
+// Set marshal value and set it into cache
+func (c *redisCache) Set(ctx context.Context, key string, val interface{}, ttl time.Duration) error {
+	data, err := c.encode(val)
+	if err != nil {
+		return err
+	}
+	return c.setBytes(ctx, key, data, ttl)
+}
+
+// Delete delete keys from cache
+func (c *redisCache) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	cacheKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		cacheKeys = append(cacheKeys, c.key(key))
+	}
+	return c.client.Del(ctx, cacheKeys...).Err()
+}
+
+// SetWithTags set value and add the key into every tag group
+func (c *redisCache) SetWithTags(ctx context.Context, key string, val interface{}, ttl time.Duration, tags ...string) error {
+	data, err := c.encode(val)
+	if err != nil {
+		return err
+	}
+	return c.setBytesWithTags(ctx, key, data, ttl, tags...)
+}
+
+// InvalidateTags delete every key of the tag groups
+func (c *redisCache) InvalidateTags(ctx context.Context, tags ...string) error {
+	_, err := c.invalidateTags(ctx, tags...)
+	return err
+}
+
+// GetOrLoad get value from cache, call loader and set the result into cache when key missed.
+// Concurrent loads of the same key are executed only once, the loader runs detached from the caller's
+// cancellation and is bounded by the load timeout.
+func (c *redisCache) GetOrLoad(ctx context.Context, key string, ttl time.Duration, loader Loader, dst interface{}) error {
+	data, err := c.getOrLoadBytes(ctx, key, ttl, loader)
+	if err != nil {
+		return err
+	}
+	return c.decode(data, dst)
+}
+
+func (c *redisCache) getOrLoadBytes(ctx context.Context, key string, ttl time.Duration, loader Loader) ([]byte, error) {
+	data, err := c.getBytes(ctx, key)
+	if err == nil {
+		return data, nil
+	}
+	if !errors.Is(err, ErrCacheMiss) {
+		return nil, err
+	}
+
+	return c.group.Do(key, func() ([]byte, error) {
+		// the load is shared by every waiter, so it is not cancelled with the first caller
+		loadCtx, cancel := context.WithTimeout(log.Ctx(ctx).Attach(context.Background()), c.loadTimeout)
+		defer cancel()
+		return c.load(loadCtx, key, ttl, loader)
+	})
+}
+
+func (c *redisCache) load(ctx context.Context, key string, ttl time.Duration, loader Loader) ([]byte, error) {
+	if c.distributedLoad && c.client.Locker != nil {
+		lockKey := "cache_load." + c.key(key)
+		lock, err := c.client.Locker.Lock(ctx, lockKey, WithTTL(c.loadTimeout), WaitForContext())
+		switch {
+		case err == nil:
+			defer func() {
+				releaseCtx, cancel := context.WithTimeout(log.Ctx(ctx).Attach(context.Background()), releaseTimeout)
+				defer cancel()
+				if err := lock.Release(releaseCtx); err != nil {
+					log.Ctx(ctx).Field("lock_key", lockKey).Err(err).Warn("cache: release load lock failed")
+				}
+			}()
+		case ctx.Err() != nil:
+			return nil, ctx.Err()
+		default:
+			log.Ctx(ctx).Field("lock_key", lockKey).Err(err).Warn("cache: obtain load lock failed, load without lock")
+		}
+
+		// another process may have loaded the value while we were waiting
+		data, err := c.getBytes(ctx, key)
+		if err == nil {
+			return data, nil
+		}
+		if !errors.Is(err, ErrCacheMiss) {
+			return nil, err
+		}
+	}
+
+	val, err := loader(ctx)
+	if errors.Is(err, ErrNotFound) {
+		if c.negativeTTL > 0 {
+			if err := c.setBytes(ctx, key, negativeValue, c.negativeTTL); err != nil {
+				return nil, err
+			}
+		}
+		return negativeValue, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := c.encode(val)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.setBytes(ctx, key, data, ttl); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (c *redisCache) getBytes(ctx context.Context, key string) ([]byte, error) {
+	data, err := c.client.Get(ctx, c.key(key)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrCacheMiss
+	}
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// getBytesWithTTL return the value with its remaining ttl, the ttl is 0 if the key has no expiry
+func (c *redisCache) getBytesWithTTL(ctx context.Context, key string) ([]byte, time.Duration, error) {
+	// read both in a transaction, so a value is never paired with the ttl of a later write
+	pipe := c.client.TxPipeline()
+	get := pipe.Get(ctx, c.key(key))
+	pttl := pipe.PTTL(ctx, c.key(key))
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, 0, err
+	}
+
+	data, err := get.Bytes()
+	if err == redis.Nil {
+		return nil, 0, ErrCacheMiss
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	remain := pttl.Val()
+	if remain < 0 {
+		remain = 0
+	}
+	return data, remain, nil
+}
+
+func (c *redisCache) setBytes(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	return c.client.Set(ctx, c.key(key), data, ttl).Err()
+}
+
+func (c *redisCache) setBytesWithTags(ctx context.Context, key string, data []byte, ttl time.Duration, tags ...string) error {
+	if ttl <= 0 {
+		return ErrInvalidTagTTL
+	}
+	_, err := c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, c.key(key), data, ttl)
+		for _, tag := range tags {
+			luaTagAdd.Eval(ctx, pipe, []string{c.tagKey(tag)}, key, ttl.Milliseconds())
+		}
+		return nil
+	})
+	return err
+}
+
+// invalidateTags return the invalidated keys, keys tagged again meanwhile stay in their tag groups
+func (c *redisCache) invalidateTags(ctx context.Context, tags ...string) ([]string, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	members := make([][]redis.Z, 0, len(tags))
+	for _, tag := range tags {
+		zs, err := c.client.ZRangeWithScores(ctx, c.tagKey(tag), 0, -1).Result()
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, zs)
+	}
+
+	var keys []string
+	_, err := c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, tag := range tags {
+			if len(members[i]) == 0 {
+				continue
+			}
+			args := make([]interface{}, 0, 2*len(members[i]))
+			for _, z := range members[i] {
+				key := z.Member.(string)
+				pipe.Del(ctx, c.key(key))
+				keys = append(keys, key)
+				args = append(args, key, strconv.FormatFloat(z.Score, 'f', -1, 64))
+			}
+			luaTagRemove.Eval(ctx, pipe, []string{c.tagKey(tag)}, args...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (c *redisCache) encode(val interface{}) ([]byte, error) {
+	return c.payload.encode(val)
+}
+
+func (c *redisCache) decode(data []byte, dst interface{}) error {
+	if bytes.Equal(data, negativeValue) {
+		return ErrNotFound
+	}
+	return c.payload.decode(data, dst)
+}
@@ -0,0 +1,240 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlightGroup(t *testing.T) {
+	var (
+		g     flightGroup
+		calls int32
+		wg    sync.WaitGroup
+	)
+	release := make(chan struct{})
+	fn := func() ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return []byte("val"), nil
+	}
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			val, err := g.Do("key", fn)
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("val"), val)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), calls)
+
+	// the call is forgotten once it returns
+	_, _ = g.Do("key", fn)
+	assert.Equal(t, int32(2), calls)
+}
+
+func TestFlightGroupPanic(t *testing.T) {
+	var g flightGroup
+	started := make(chan struct{})
+	release := make(chan struct{})
+	panicked := make(chan interface{})
+	go func() {
+		defer func() { panicked <- recover() }()
+		_, _ = g.Do("key", func() ([]byte, error) {
+			close(started)
+			<-release
+			panic("boom")
+		})
+	}()
+
+	<-started
+	waiter := make(chan error)
+	go func() {
+		_, err := g.Do("key", func() ([]byte, error) { return nil, nil })
+		waiter <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	assert.Equal(t, "boom", <-panicked)
+	assert.Equal(t, errLoadPanicked, <-waiter)
+}
+
+func TestCacheNegativeValue(t *testing.T) {
+	client := newTestRedis(t)
+	c, err := newRedisCache(client, &CacheCfg{Prefix: "test", NegativeTTLSec: 10})
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	// encoded payloads never collide with the negative marker
+	for _, val := range []interface{}{nil, "", "nil", []byte{}, 0} {
+		data, err := c.encode(val)
+		assert.NoError(t, err)
+		assert.NotEqual(t, negativeValue, data)
+	}
+	assert.Equal(t, ErrNotFound, c.decode(negativeValue, &codecItem{}))
+
+	loads := 0
+	loader := func(ctx context.Context) (interface{}, error) {
+		loads++
+		return nil, ErrNotFound
+	}
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, ErrNotFound, c.GetOrLoad(ctx, "missing", time.Minute, loader, &codecItem{}))
+	}
+	assert.Equal(t, 1, loads)
+	data, err := client.Get(ctx, "test.missing").Bytes()
+	assert.NoError(t, err)
+	assert.Equal(t, negativeValue, data)
+	ttl, err := client.TTL(ctx, "test.missing").Result()
+	assert.NoError(t, err)
+	assert.True(t, ttl > 9*time.Second && ttl <= 10*time.Second)
+}
+
+func TestCacheDistributedLoad(t *testing.T) {
+	client := newTestRedis(t)
+	ctx := context.Background()
+
+	var (
+		loads int32
+		wg    sync.WaitGroup
+	)
+	loader := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		time.Sleep(50 * time.Millisecond)
+		return codecItem{ID: 1, Name: "gox"}, nil
+	}
+
+	// caches of separate processes do not share flight groups, only the locker
+	for i := 0; i < 3; i++ {
+		c, err := newRedisCache(client, &CacheCfg{Prefix: "test", DistributedLoad: true})
+		assert.NoError(t, err)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			item := codecItem{}
+			assert.NoError(t, c.GetOrLoad(ctx, "item", time.Minute, loader, &item))
+			assert.Equal(t, codecItem{ID: 1, Name: "gox"}, item)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), loads)
+}
+
+func TestCacheTags(t *testing.T) {
+	client := newTestRedis(t)
+	c, err := newRedisCache(client, &CacheCfg{Prefix: "test"})
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	assert.Equal(t, "test.tags.user", c.tagKey("user"))
+	assert.Equal(t, ErrInvalidTagTTL, c.SetWithTags(ctx, "user.0", codecItem{}, 0, "user"))
+	assert.NoError(t, c.SetWithTags(ctx, "user.1", codecItem{ID: 1}, time.Minute, "user", "team.1"))
+	assert.NoError(t, c.SetWithTags(ctx, "user.2", codecItem{ID: 2}, time.Minute, "user"))
+	assert.NoError(t, c.Set(ctx, "team.1", codecItem{ID: 1}, time.Minute))
+	members, err := client.ZRange(ctx, "test.tags.user", 0, -1).Result()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"user.1", "user.2"}, members)
+	members, err = client.ZRange(ctx, "test.tags.team.1", 0, -1).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"user.1"}, members)
+
+	keys, err := c.invalidateTags(ctx, "team.1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"user.1"}, keys)
+	assert.Equal(t, ErrCacheMiss, c.Get(ctx, "user.1", &codecItem{}))
+	assert.Equal(t, int64(0), client.Exists(ctx, "test.tags.team.1").Val())
+
+	item := codecItem{}
+	assert.NoError(t, c.Get(ctx, "user.2", &item))
+	assert.Equal(t, codecItem{ID: 2}, item)
+	assert.NoError(t, c.Get(ctx, "team.1", &item))
+
+	assert.NoError(t, c.InvalidateTags(ctx, "user"))
+	assert.Equal(t, ErrCacheMiss, c.Get(ctx, "user.2", &codecItem{}))
+	assert.Equal(t, int64(0), client.Exists(ctx, "test.tags.user").Val())
+	assert.NoError(t, c.InvalidateTags(ctx))
+	assert.NoError(t, c.InvalidateTags(ctx, "missing"))
+}
+
+func TestCacheTagsExpiry(t *testing.T) {
+	client := newTestRedis(t)
+	c, err := newRedisCache(client, &CacheCfg{Prefix: "test"})
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	// the tag set lives as long as its longest living member
+	assert.NoError(t, c.SetWithTags(ctx, "long", codecItem{}, time.Minute, "group"))
+	assert.NoError(t, c.SetWithTags(ctx, "short", codecItem{}, 50*time.Millisecond, "group"))
+	ttl, err := client.PTTL(ctx, "test.tags.group").Result()
+	assert.NoError(t, err)
+	assert.True(t, ttl > 50*time.Second)
+
+	// expired members are pruned by the next write
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, c.SetWithTags(ctx, "other", codecItem{}, time.Minute, "group"))
+	members, err := client.ZRange(ctx, "test.tags.group", 0, -1).Result()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"long", "other"}, members)
+}
+
+func TestCacheTagsRewrittenDuringInvalidation(t *testing.T) {
+	client := newTestRedis(t)
+	c, err := newRedisCache(client, &CacheCfg{Prefix: "test"})
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	assert.NoError(t, c.SetWithTags(ctx, "a", codecItem{}, time.Minute, "group"))
+	assert.NoError(t, c.SetWithTags(ctx, "b", codecItem{}, time.Minute, "group"))
+	score := client.ZScore(ctx, "test.tags.group", "a").Val()
+
+	// a is tagged again with a new expiry after the invalidation read the group
+	assert.NoError(t, client.ZAdd(ctx, "test.tags.group", &redis.Z{Score: score + 1000, Member: "a"}).Err())
+	removed, err := luaTagRemove.Run(ctx, client, []string{"test.tags.group"}, "a", score, "b", client.ZScore(ctx, "test.tags.group", "b").Val()).Int()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	members, err := client.ZRange(ctx, "test.tags.group", 0, -1).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a"}, members)
+}
+
+func TestCacheLoadDetachedFromCaller(t *testing.T) {
+	client := newTestRedis(t)
+	c, err := newRedisCache(client, &CacheCfg{Prefix: "test", LoadTimeoutMs: 1000})
+	assert.NoError(t, err)
+
+	started := make(chan struct{})
+	loader := func(ctx context.Context) (interface{}, error) {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		_, hasDeadline := ctx.Deadline()
+		assert.True(t, hasDeadline)
+		return codecItem{ID: 1}, ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() { first <- c.GetOrLoad(ctx, "item", time.Minute, loader, &codecItem{}) }()
+	<-started
+
+	// the waiter shares the load of the first caller, which gives up meanwhile
+	item := codecItem{}
+	waiter := make(chan error)
+	go func() { waiter <- c.GetOrLoad(context.Background(), "item", time.Minute, loader, &item) }()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	assert.NoError(t, <-first)
+	assert.NoError(t, <-waiter)
+	assert.Equal(t, codecItem{ID: 1}, item)
+}
@@ -0,0 +1,259 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+var (
+	// ErrUnknownCodec is returned when payload is encoded by a codec which is not registered
+	ErrUnknownCodec = errors.New("cache: unknown codec")
+	// ErrUnknownCompression is returned when payload is compressed by unknown algorithm
+	ErrUnknownCompression = errors.New("cache: unknown compression")
+	// ErrUnknownPayloadVersion is returned when payload header is written by a newer version
+	ErrUnknownPayloadVersion = errors.New("cache: unknown payload version")
+	// ErrNotProtoMessage is returned when proto codec marshal a value which is not proto.Message
+	ErrNotProtoMessage = errors.New("cache: value is not proto.Message")
+)
+
+// payload header: magic, version, codec id, compression id.
+// The magic byte never appears at the beginning of json, so payloads written before
+// headers were introduced are still decoded as json.
+const (
+	payloadMagic     byte = 0xfe
+	payloadVersion   byte = 1
+	payloadHeaderLen      = 4
+)
+
+// Codec marshal cached values
+type Codec interface {
+	// ID identify the codec in payload header, it must be unique and never change
+	ID() byte
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// JSONCodec encoding/json codec
+	JSONCodec Codec = jsonCodec{}
+	// GobCodec encoding/gob codec
+	GobCodec Codec = gobCodec{}
+	// ProtoCodec protobuf codec for proto.Message values
+	ProtoCodec Codec = protoCodec{}
+	// MsgpackCodec msgpack binary codec
+	MsgpackCodec Codec = msgpackCodec{}
+)
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[byte]Codec{
+		JSONCodec.ID():    JSONCodec,
+		GobCodec.ID():     GobCodec,
+		ProtoCodec.ID():   ProtoCodec,
+		MsgpackCodec.ID(): MsgpackCodec,
+	}
+)
+
+// RegisterCodec register custom codec, so that payloads written by it can be decoded
+func RegisterCodec(codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[codec.ID()] = codec
+}
+
+func getCodec(id byte) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	codec, ok := codecs[id]
+	return codec, ok
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ID() byte { return 1 }
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type gobCodec struct{}
+
+func (gobCodec) ID() byte { return 2 }
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type protoCodec struct{}
+
+func (protoCodec) ID() byte { return 3 }
+
+func (protoCodec) Marshal(v interface{}) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, ErrNotProtoMessage
+	}
+	return proto.Marshal(msg)
+}
+
+func (protoCodec) Unmarshal(data []byte, v interface{}) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return ErrNotProtoMessage
+	}
+	return proto.Unmarshal(data, msg)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ID() byte { return 4 }
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) { return msgpack.Marshal(v) }
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error { return msgpack.Unmarshal(data, v) }
+
+// Compression payload compression algorithm
+type Compression string
+
+const (
+	// NoCompression do not compress payloads
+	NoCompression Compression = ""
+	// Gzip compress payloads by gzip
+	Gzip Compression = "gzip"
+	// Snappy compress payloads by snappy
+	Snappy Compression = "snappy"
+)
+
+func (c Compression) id() byte {
+	switch c {
+	case Gzip:
+		return 1
+	case Snappy:
+		return 2
+	}
+	return 0
+}
+
+func (c Compression) validate() error {
+	switch c {
+	case NoCompression, Gzip, Snappy:
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrUnknownCompression, c)
+}
+
+// payloadCodec encode values into versioned payloads, and decode payloads of any registered codec,
+// so codec and compression changes can roll out without flushing redis
+type payloadCodec struct {
+	codec       Codec
+	compression Compression
+	threshold   int
+}
+
+func newPayloadCodec(codec Codec, compression Compression, threshold int) (*payloadCodec, error) {
+	if err := compression.validate(); err != nil {
+		return nil, err
+	}
+	if codec == nil {
+		codec = JSONCodec
+	}
+	return &payloadCodec{
+		codec:       codec,
+		compression: compression,
+		threshold:   threshold,
+	}, nil
+}
+
+func (p *payloadCodec) encode(v interface{}) ([]byte, error) {
+	data, err := p.codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	compression := NoCompression
+	if p.compression != NoCompression && len(data) >= p.threshold {
+		compressed, err := compress(p.compression, data)
+		if err != nil {
+			return nil, err
+		}
+		if len(compressed) < len(data) {
+			data, compression = compressed, p.compression
+		}
+	}
+
+	payload := make([]byte, 0, payloadHeaderLen+len(data))
+	payload = append(payload, payloadMagic, payloadVersion, p.codec.ID(), compression.id())
+	return append(payload, data...), nil
+}
+
+func (p *payloadCodec) decode(payload []byte, v interface{}) error {
+	if len(payload) < payloadHeaderLen || payload[0] != payloadMagic {
+		return JSONCodec.Unmarshal(payload, v)
+	}
+	if payload[1] != payloadVersion {
+		return ErrUnknownPayloadVersion
+	}
+
+	codec, ok := getCodec(payload[2])
+	if !ok {
+		return ErrUnknownCodec
+	}
+	data, err := decompress(payload[3], payload[payloadHeaderLen:])
+	if err != nil {
+		return err
+	}
+	return codec.Unmarshal(data, v)
+}
+
+func compress(compression Compression, data []byte) ([]byte, error) {
+	switch compression {
+	case Snappy:
+		return snappy.Encode(nil, data), nil
+	case Gzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, ErrUnknownCompression
+}
+
+func decompress(id byte, data []byte) ([]byte, error) {
+	switch id {
+	case NoCompression.id():
+		return data, nil
+	case Snappy.id():
+		return snappy.Decode(nil, data)
+	case Gzip.id():
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	}
+	return nil, ErrUnknownCompression
+}
@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/stretchr/testify/assert"
+)
+
+type codecItem struct {
+	ID   int64
+	Name string
+}
+
+func TestPayloadCodec(t *testing.T) {
+	item := codecItem{ID: 1, Name: strings.Repeat("gox", 100)}
+
+	for _, codec := range []Codec{JSONCodec, GobCodec, MsgpackCodec} {
+		for _, compression := range []Compression{NoCompression, Gzip, Snappy} {
+			p, err := newPayloadCodec(codec, compression, 64)
+			assert.NoError(t, err)
+			data, err := p.encode(item)
+			assert.NoError(t, err)
+			if compression != NoCompression {
+				assert.Equal(t, compression.id(), data[3])
+			}
+
+			// payloads are decoded by the codec recorded in header regardless of current config
+			decoded := codecItem{}
+			assert.NoError(t, (&payloadCodec{codec: JSONCodec}).decode(data, &decoded))
+			assert.Equal(t, item, decoded)
+		}
+	}
+}
+
+func TestPayloadCodecProto(t *testing.T) {
+	p := &payloadCodec{codec: ProtoCodec}
+	data, err := p.encode(&wrappers.StringValue{Value: "gox"})
+	assert.NoError(t, err)
+
+	decoded := &wrappers.StringValue{}
+	assert.NoError(t, p.decode(data, decoded))
+	assert.Equal(t, "gox", decoded.Value)
+
+	_, err = p.encode(codecItem{})
+	assert.Equal(t, ErrNotProtoMessage, err)
+}
+
+func TestPayloadCodecLegacyJSON(t *testing.T) {
+	decoded := codecItem{}
+	p := &payloadCodec{codec: MsgpackCodec, compression: Snappy}
+	assert.NoError(t, p.decode([]byte(`{"ID":1,"Name":"gox"}`), &decoded))
+	assert.Equal(t, codecItem{ID: 1, Name: "gox"}, decoded)
+}
+
+func TestPayloadCodecValidate(t *testing.T) {
+	_, err := newPayloadCodec(JSONCodec, "lz4", 0)
+	assert.True(t, errors.Is(err, ErrUnknownCompression))
+
+	p, err := newPayloadCodec(JSONCodec, NoCompression, 0)
+	assert.NoError(t, err)
+	data, err := p.encode(codecItem{ID: 1})
+	assert.NoError(t, err)
+
+	// payloads of a newer layout are refused instead of decoded by a wrong header
+	data[1] = payloadVersion + 1
+	assert.Equal(t, ErrUnknownPayloadVersion, p.decode(data, &codecItem{}))
+}
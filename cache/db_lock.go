@@ -0,0 +1,222 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/vx416/gox/dbprovider"
+)
+
+// ErrUnsupportedDB is returned when database type has no advisory lock support
+var ErrUnsupportedDB = errors.New("cache: database does not support advisory lock")
+
+// dbUnlockTimeout bound unlocking, it is independent of the caller context
+const dbUnlockTimeout = 5 * time.Second
+
+// NewDBLocker construct a locker backed by postgres advisory locks or mysql GET_LOCK.
+// Every lock holds its own connection until released, advisory locks do not expire by themselves,
+// so TTL only reports the nominal lease while the connection still owns the lock.
+func NewDBLocker(provider dbprovider.DBProvider, dbType dbprovider.DBType, prefix string, defaultTTL time.Duration) (Locker, error) {
+	if dbType != dbprovider.Pg && dbType != dbprovider.Mysql {
+		return nil, ErrUnsupportedDB
+	}
+	if defaultTTL <= 0 {
+		defaultTTL = time.Second
+	}
+
+	return &dbLocker{
+		provider:   provider,
+		dbType:     dbType,
+		prefix:     prefix,
+		defaultTTL: defaultTTL,
+	}, nil
+}
+
+type dbLocker struct {
+	provider   dbprovider.DBProvider
+	dbType     dbprovider.DBType
+	prefix     string
+	defaultTTL time.Duration
+}
+
+// Do run fn while holding the lock of key
+func (locker *dbLocker) Do(ctx context.Context, key string, fn func(ctx context.Context) error, opts ...LockOption) error {
+	return doWithLock(ctx, locker, key, fn, opts...)
+}
+
+// Lock obtain the advisory lock, acquisition options are the same as the redis locker
+func (locker *dbLocker) Lock(ctx context.Context, key string, opts ...LockOption) (Releaser, error) {
+	o := newLockOptions(locker.defaultTTL, opts...)
+	if o.fencing {
+		return nil, ErrFencingUnsupported
+	}
+	db, err := locker.provider.DB()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := &dbLock{
+		conn:     conn,
+		dbType:   locker.dbType,
+		id:       lockID(locker.prefix + "." + key),
+		expireAt: time.Now().Add(o.ttl),
+	}
+	err = o.obtain(ctx, lock.tryLock)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return lock, nil
+}
+
+// lockID hash the key to a 64 bits advisory lock id
+func lockID(key string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return int64(h.Sum64())
+}
+
+type dbLock struct {
+	mu        sync.Mutex
+	conn      *sql.Conn
+	dbType    dbprovider.DBType
+	id        int64
+	expireAt  time.Time
+	discarded bool
+}
+
+// name mysql lock name, it is limited to 64 characters
+func (lock *dbLock) name() string {
+	return "gox." + strconv.FormatUint(uint64(lock.id), 16)
+}
+
+func (lock *dbLock) tryLock(ctx context.Context) (bool, error) {
+	var ok sql.NullBool
+	var err error
+	switch lock.dbType {
+	case dbprovider.Pg:
+		err = lock.conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", lock.id).Scan(&ok)
+	case dbprovider.Mysql:
+		err = lock.conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, 0)", lock.name()).Scan(&ok)
+	}
+	if err != nil {
+		return false, err
+	}
+	return ok.Valid && ok.Bool, nil
+}
+
+// held check whether the lock is still owned by the connection
+func (lock *dbLock) held(ctx context.Context) (bool, error) {
+	var ok sql.NullBool
+	var err error
+	switch lock.dbType {
+	case dbprovider.Pg:
+		err = lock.conn.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM pg_locks WHERE locktype = 'advisory'
+			AND pid = pg_backend_pid() AND ((classid::bigint << 32) | objid::bigint) = $1)`, lock.id).Scan(&ok)
+	case dbprovider.Mysql:
+		err = lock.conn.QueryRowContext(ctx, "SELECT IS_USED_LOCK(?) = CONNECTION_ID()", lock.name()).Scan(&ok)
+	}
+	if err != nil {
+		return false, err
+	}
+	return ok.Valid && ok.Bool, nil
+}
+
+// Release unlock and return the connection to pool. The unlock runs with its own deadline, since a pooled
+// connection which still holds the session level lock would block other holders until it is closed.
+// If unlocking fails the connection is discarded instead of being returned to pool.
+func (lock *dbLock) Release(ctx context.Context) error {
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+	if lock.discarded {
+		return ErrLockNotHeld
+	}
+
+	unlockCtx, cancel := context.WithTimeout(context.Background(), dbUnlockTimeout)
+	defer cancel()
+
+	var ok sql.NullBool
+	var err error
+	switch lock.dbType {
+	case dbprovider.Pg:
+		err = lock.conn.QueryRowContext(unlockCtx, "SELECT pg_advisory_unlock($1)", lock.id).Scan(&ok)
+	case dbprovider.Mysql:
+		err = lock.conn.QueryRowContext(unlockCtx, "SELECT RELEASE_LOCK(?)", lock.name()).Scan(&ok)
+	}
+	if err != nil {
+		lock.discard()
+		return err
+	}
+	lock.discarded = true
+	lock.conn.Close()
+	if !ok.Valid || !ok.Bool {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// Refresh verify the connection still owns the lock and extend the nominal lease,
+// the connection is discarded once the lock is lost or its ownership is unknown
+func (lock *dbLock) Refresh(ctx context.Context, ttl time.Duration) error {
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+	if lock.discarded {
+		return ErrLockNotHeld
+	}
+
+	ok, err := lock.held(ctx)
+	if err != nil {
+		lock.discard()
+		return err
+	}
+	if !ok {
+		lock.discard()
+		return ErrLockNotHeld
+	}
+	lock.expireAt = time.Now().Add(ttl)
+	return nil
+}
+
+// discard close the connection without returning it to pool, so a lock it may still hold is dropped with the session
+func (lock *dbLock) discard() {
+	lock.discarded = true
+	_ = lock.conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	lock.conn.Close()
+}
+
+func (lock *dbLock) FencingToken() (int64, bool) {
+	return 0, false
+}
+
+// TTL return the nominal lease, 0 if the connection lost the lock
+func (lock *dbLock) TTL(ctx context.Context) (time.Duration, error) {
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+	if lock.discarded {
+		return 0, nil
+	}
+
+	ok, err := lock.held(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		lock.discard()
+		return 0, nil
+	}
+	if ttl := time.Until(lock.expireAt); ttl > 0 {
+		return ttl, nil
+	}
+	return 0, nil
+}
@@ -0,0 +1,351 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/rs/xid"
+	"github.com/vx416/gox/log"
+)
+
+// ErrJobNotFound is returned when acking or cancelling a job which is already done or cancelled,
+// or acking a delivery which is superseded by a later delivery of the job
+var ErrJobNotFound = errors.New("cache: job not found")
+
+// due jobs are scored by their due time, in-flight jobs by their visibility deadline, both in redis server time.
+// Payloads are kept in a hash, so the sorted sets only carry job ids. Every delivery increments the attempt
+// of the job, the attempt is the receipt of the delivery, so only the latest delivery can ack or nack the job.
+var (
+	luaQueueEnqueue = redis.NewScript(`
+redis.replicate_commands()
+local t = redis.call("time")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+redis.call("hset", KEYS[2], ARGV[1], ARGV[2])
+redis.call("zadd", KEYS[1], now + tonumber(ARGV[3]), ARGV[1])
+return 1`)
+	// KEYS due, inflight, jobs, attempts, dead; ARGV visibility ms, limit, max attempts.
+	// It returns id, attempt and payload of every claimed job.
+	luaQueuePoll = redis.NewScript(`
+redis.replicate_commands()
+local t = redis.call("time")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local limit = tonumber(ARGV[2])
+local maxAttempts = tonumber(ARGV[3])
+local expired = redis.call("zrangebyscore", KEYS[2], "-inf", now, "limit", 0, limit)
+for _, id in ipairs(expired) do
+	redis.call("zrem", KEYS[2], id)
+	redis.call("zadd", KEYS[1], now, id)
+end
+local jobs = {}
+local due = redis.call("zrangebyscore", KEYS[1], "-inf", now, "limit", 0, limit)
+for _, id in ipairs(due) do
+	redis.call("zrem", KEYS[1], id)
+	local payload = redis.call("hget", KEYS[3], id)
+	if payload then
+		local attempt = redis.call("hincrby", KEYS[4], id, 1)
+		if attempt > maxAttempts then
+			redis.call("hset", KEYS[5], id, payload)
+			redis.call("hdel", KEYS[3], id)
+			redis.call("hdel", KEYS[4], id)
+		else
+			redis.call("zadd", KEYS[2], now + tonumber(ARGV[1]), id)
+			table.insert(jobs, id)
+			table.insert(jobs, attempt)
+			table.insert(jobs, payload)
+		end
+	end
+end
+return jobs`)
+	// KEYS inflight, jobs, attempts; ARGV id, attempt
+	luaQueueAck = redis.NewScript(`
+if redis.call("hget", KEYS[3], ARGV[1]) ~= ARGV[2] or redis.call("zrem", KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call("hdel", KEYS[2], ARGV[1])
+redis.call("hdel", KEYS[3], ARGV[1])
+return 1`)
+	// KEYS inflight, due, attempts; ARGV id, attempt, delay ms
+	luaQueueNack = redis.NewScript(`
+redis.replicate_commands()
+if redis.call("hget", KEYS[3], ARGV[1]) ~= ARGV[2] or redis.call("zrem", KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+local t = redis.call("time")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+redis.call("zadd", KEYS[2], now + tonumber(ARGV[3]), ARGV[1])
+return 1`)
+	luaQueueCancel = redis.NewScript(`
+redis.call("zrem", KEYS[1], ARGV[1])
+redis.call("zrem", KEYS[2], ARGV[1])
+redis.call("hdel", KEYS[4], ARGV[1])
+return redis.call("hdel", KEYS[3], ARGV[1])`)
+)
+
+// Job delayed job delivered to workers
+type Job struct {
+	ID      string
+	Attempt int // deliveries of the job so far, it starts from 1
+	payload []byte
+	codec   *payloadCodec
+}
+
+// Decode decode job payload into dst
+func (job *Job) Decode(dst interface{}) error {
+	return job.codec.decode(job.payload, dst)
+}
+
+// JobHandler handle one job, the job is acknowledged when it returns nil,
+// otherwise it is delivered again after a backoff growing with its attempts
+type JobHandler func(ctx context.Context, job *Job) error
+
+// DelayedQueue at-least-once delayed job queue on redis sorted sets
+type DelayedQueue interface {
+	// Enqueue schedule payload to be delivered after delay and return the job id
+	Enqueue(ctx context.Context, payload interface{}, delay time.Duration) (string, error)
+	// EnqueueAt schedule payload to be delivered at the given time and return the job id
+	EnqueueAt(ctx context.Context, payload interface{}, at time.Time) (string, error)
+	// Cancel remove a pending or in-flight job, it returns ErrJobNotFound if the job is done or cancelled
+	Cancel(ctx context.Context, id string) error
+	// Poll claim at most max due jobs, claimed jobs are delivered again if they are not acked within visibility timeout.
+	// Jobs which exceed max attempts are moved to the dead letter hash instead of being delivered.
+	Poll(ctx context.Context, max int) ([]*Job, error)
+	// Ack mark a claimed job as done, it returns ErrJobNotFound if the job was cancelled or delivered again
+	Ack(ctx context.Context, job *Job) error
+	// Nack give a claimed job back to be delivered again after delay,
+	// it returns ErrJobNotFound if the job was cancelled or delivered again
+	Nack(ctx context.Context, job *Job, delay time.Duration) error
+	// Run poll and handle due jobs until ctx is done, in-flight jobs are finished before it returns
+	Run(ctx context.Context, handler JobHandler) error
+}
+
+// DelayedQueueCfg delayed queue config
+type DelayedQueueCfg struct {
+	Name                 string      `yaml:"name"`
+	VisibilityTimeoutSec int         `yaml:"visibility_timeout_sec"` // claimed jobs are delivered again after it, default is 30 seconds
+	MaxAttempts          int         `yaml:"max_attempts"`           // jobs delivered more times are moved to hash {name}.dead, default is 10
+	PollIntervalMs       int         `yaml:"poll_interval_ms"`
+	BatchSize            int         `yaml:"batch_size"`
+	Workers              int         `yaml:"workers"`
+	Compression          Compression `yaml:"compression"`
+	CompressThreshold    int         `yaml:"compress_threshold"`
+	Codec                Codec       `yaml:"-"` // default is JSONCodec
+}
+
+// NewDelayedQueue construct a delayed queue, keys of the queue share a cluster hash slot
+func NewDelayedQueue(client redis.Cmdable, cfg *DelayedQueueCfg) (DelayedQueue, error) {
+	payload, err := newPayloadCodec(cfg.Codec, cfg.Compression, cfg.CompressThreshold)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &delayedQueue{
+		client:       client,
+		dueKey:       "{" + cfg.Name + "}.due",
+		inflightKey:  "{" + cfg.Name + "}.inflight",
+		jobsKey:      "{" + cfg.Name + "}.jobs",
+		attemptsKey:  "{" + cfg.Name + "}.attempts",
+		deadKey:      "{" + cfg.Name + "}.dead",
+		visibility:   time.Duration(cfg.VisibilityTimeoutSec) * time.Second,
+		maxAttempts:  cfg.MaxAttempts,
+		pollInterval: time.Duration(cfg.PollIntervalMs) * time.Millisecond,
+		batchSize:    cfg.BatchSize,
+		workers:      cfg.Workers,
+		payload:      payload,
+	}
+
+	if q.visibility <= 0 {
+		q.visibility = 30 * time.Second
+	}
+	if q.maxAttempts <= 0 {
+		q.maxAttempts = 10
+	}
+	if q.pollInterval <= 0 {
+		q.pollInterval = time.Second
+	}
+	if q.workers <= 0 {
+		q.workers = runtime.NumCPU()
+	}
+	if q.batchSize <= 0 {
+		q.batchSize = q.workers
+	}
+	return q, nil
+}
+
+type delayedQueue struct {
+	client       redis.Cmdable
+	dueKey       string
+	inflightKey  string
+	jobsKey      string
+	attemptsKey  string
+	deadKey      string
+	visibility   time.Duration
+	maxAttempts  int
+	pollInterval time.Duration
+	batchSize    int
+	workers      int
+	payload      *payloadCodec
+}
+
+func (q *delayedQueue) Enqueue(ctx context.Context, payload interface{}, delay time.Duration) (string, error) {
+	data, err := q.payload.encode(payload)
+	if err != nil {
+		return "", err
+	}
+	if delay < 0 {
+		delay = 0
+	}
+
+	id := xid.New().String()
+	err = luaQueueEnqueue.Run(ctx, q.client, []string{q.dueKey, q.jobsKey}, id, data, delay.Milliseconds()).Err()
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+func (q *delayedQueue) EnqueueAt(ctx context.Context, payload interface{}, at time.Time) (string, error) {
+	return q.Enqueue(ctx, payload, time.Until(at))
+}
+
+func (q *delayedQueue) Cancel(ctx context.Context, id string) error {
+	n, err := luaQueueCancel.Run(ctx, q.client, []string{q.dueKey, q.inflightKey, q.jobsKey, q.attemptsKey}, id).Int()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+func (q *delayedQueue) Poll(ctx context.Context, max int) ([]*Job, error) {
+	keys := []string{q.dueKey, q.inflightKey, q.jobsKey, q.attemptsKey, q.deadKey}
+	res, err := luaQueuePoll.Run(ctx, q.client, keys, q.visibility.Milliseconds(), max, q.maxAttempts).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, ok := res.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("cache: unexpected poll reply %v", res)
+	}
+	jobs := make([]*Job, 0, len(reply)/3)
+	for i := 0; i+2 < len(reply); i += 3 {
+		id, _ := reply[i].(string)
+		attempt, _ := reply[i+1].(int64)
+		payload, _ := reply[i+2].(string)
+		jobs = append(jobs, &Job{ID: id, Attempt: int(attempt), payload: []byte(payload), codec: q.payload})
+	}
+	return jobs, nil
+}
+
+func (q *delayedQueue) Ack(ctx context.Context, job *Job) error {
+	n, err := luaQueueAck.Run(ctx, q.client, []string{q.inflightKey, q.jobsKey, q.attemptsKey}, job.ID, job.Attempt).Int()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+func (q *delayedQueue) Nack(ctx context.Context, job *Job, delay time.Duration) error {
+	if delay < 0 {
+		delay = 0
+	}
+	n, err := luaQueueNack.Run(ctx, q.client, []string{q.inflightKey, q.dueKey, q.attemptsKey}, job.ID, job.Attempt, delay.Milliseconds()).Int()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+func (q *delayedQueue) Run(ctx context.Context, handler JobHandler) error {
+	// handlers run with a context detached from ctx cancellation, so in-flight jobs can finish on shutdown
+	baseCtx := log.Ctx(ctx).Field("queue", q.dueKey).Attach(context.Background())
+	jobs := make(chan *Job)
+	var wg sync.WaitGroup
+	for i := 0; i < q.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				q.process(baseCtx, handler, job)
+			}
+		}()
+	}
+	defer func() {
+		close(jobs)
+		wg.Wait()
+	}()
+
+	for ctx.Err() == nil {
+		claimed, err := q.Poll(ctx, q.batchSize)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Ctx(ctx).Err(err).Warn("delayed queue: poll failed")
+			}
+			sleepCtx(ctx, q.pollInterval)
+			continue
+		}
+
+		for _, job := range claimed {
+			select {
+			case jobs <- job:
+			case <-ctx.Done():
+				// undispatched jobs are delivered again after the visibility timeout
+				return nil
+			}
+		}
+		if len(claimed) < q.batchSize {
+			sleepCtx(ctx, q.pollInterval)
+		}
+	}
+	return nil
+}
+
+func (q *delayedQueue) process(ctx context.Context, handler JobHandler, job *Job) {
+	logger := log.Ctx(ctx).Field("job_id", job.ID)
+	ctx = logger.Attach(ctx)
+
+	if err := q.handle(ctx, handler, job); err != nil {
+		logger.Err(err).Warn("delayed queue: handle job failed, it will be delivered again")
+		if err := q.Nack(ctx, job, q.retryBackoff(job.Attempt)); err != nil {
+			logger.Err(err).Warn("delayed queue: nack job failed")
+		}
+		return
+	}
+	if err := q.Ack(ctx, job); err != nil {
+		logger.Err(err).Warn("delayed queue: ack job failed")
+	}
+}
+
+// retryBackoff double the delay of every failed attempt from one second, bounded by the visibility timeout
+func (q *delayedQueue) retryBackoff(attempt int) time.Duration {
+	backoff := time.Second
+	for i := 1; i < attempt && backoff < q.visibility; i++ {
+		backoff *= 2
+	}
+	if backoff > q.visibility {
+		backoff = q.visibility
+	}
+	return backoff
+}
+
+func (q *delayedQueue) handle(ctx context.Context, handler JobHandler, job *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %+v", r)
+		}
+	}()
+	return handler(ctx, job)
+}
@@ -0,0 +1,169 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestQueue(t *testing.T, cfg *DelayedQueueCfg) (*delayedQueue, *RedisClient) {
+	client := newTestRedis(t)
+	cfg.Name = "test"
+	q, err := NewDelayedQueue(client, cfg)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return q.(*delayedQueue), client
+}
+
+func TestDelayedQueue(t *testing.T) {
+	q, client := newTestQueue(t, &DelayedQueueCfg{})
+	ctx := context.Background()
+
+	first, err := q.Enqueue(ctx, codecItem{ID: 1}, 0)
+	assert.NoError(t, err)
+	second, err := q.Enqueue(ctx, codecItem{ID: 2}, 200*time.Millisecond)
+	assert.NoError(t, err)
+
+	jobs, err := q.Poll(ctx, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, jobs, 1) {
+		assert.Equal(t, first, jobs[0].ID)
+		assert.Equal(t, 1, jobs[0].Attempt)
+		item := codecItem{}
+		assert.NoError(t, jobs[0].Decode(&item))
+		assert.Equal(t, codecItem{ID: 1}, item)
+		assert.NoError(t, q.Ack(ctx, jobs[0]))
+		assert.Equal(t, ErrJobNotFound, q.Ack(ctx, jobs[0]))
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	jobs, err = q.Poll(ctx, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, jobs, 1) {
+		assert.Equal(t, second, jobs[0].ID)
+		assert.NoError(t, q.Ack(ctx, jobs[0]))
+	}
+	assert.Equal(t, int64(0), client.Exists(ctx, q.jobsKey, q.attemptsKey).Val())
+
+	id, err := q.Enqueue(ctx, codecItem{ID: 3}, time.Minute)
+	assert.NoError(t, err)
+	assert.NoError(t, q.Cancel(ctx, id))
+	assert.Equal(t, ErrJobNotFound, q.Cancel(ctx, id))
+}
+
+func TestDelayedQueueNack(t *testing.T) {
+	q, _ := newTestQueue(t, &DelayedQueueCfg{})
+	ctx := context.Background()
+
+	_, err := q.Enqueue(ctx, codecItem{ID: 1}, 0)
+	assert.NoError(t, err)
+	jobs, err := q.Poll(ctx, 10)
+	assert.NoError(t, err)
+	if !assert.Len(t, jobs, 1) {
+		return
+	}
+	stale := jobs[0]
+	assert.NoError(t, q.Nack(ctx, stale, 0))
+	assert.Equal(t, ErrJobNotFound, q.Nack(ctx, stale, 0))
+
+	jobs, err = q.Poll(ctx, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, jobs, 1) {
+		assert.Equal(t, 2, jobs[0].Attempt)
+		// the receipt of the first delivery can not ack the second delivery
+		assert.Equal(t, ErrJobNotFound, q.Ack(ctx, stale))
+		assert.NoError(t, q.Ack(ctx, jobs[0]))
+	}
+}
+
+func TestDelayedQueueVisibilityTimeout(t *testing.T) {
+	q, _ := newTestQueue(t, &DelayedQueueCfg{VisibilityTimeoutSec: 1})
+	ctx := context.Background()
+
+	_, err := q.Enqueue(ctx, codecItem{ID: 1}, 0)
+	assert.NoError(t, err)
+	jobs, err := q.Poll(ctx, 10)
+	assert.NoError(t, err)
+	if !assert.Len(t, jobs, 1) {
+		return
+	}
+	claimed, err := q.Poll(ctx, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, claimed)
+
+	time.Sleep(1100 * time.Millisecond)
+	redelivered, err := q.Poll(ctx, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, redelivered, 1) {
+		assert.Equal(t, 2, redelivered[0].Attempt)
+		assert.Equal(t, ErrJobNotFound, q.Ack(ctx, jobs[0]))
+		assert.NoError(t, q.Ack(ctx, redelivered[0]))
+	}
+}
+
+func TestDelayedQueueDeadLetter(t *testing.T) {
+	q, client := newTestQueue(t, &DelayedQueueCfg{MaxAttempts: 2})
+	ctx := context.Background()
+
+	id, err := q.Enqueue(ctx, codecItem{ID: 1}, 0)
+	assert.NoError(t, err)
+	for attempt := 1; attempt <= 2; attempt++ {
+		jobs, err := q.Poll(ctx, 10)
+		assert.NoError(t, err)
+		if assert.Len(t, jobs, 1) {
+			assert.Equal(t, attempt, jobs[0].Attempt)
+			assert.NoError(t, q.Nack(ctx, jobs[0], 0))
+		}
+	}
+
+	jobs, err := q.Poll(ctx, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, jobs)
+	payload, err := client.HGet(ctx, "{test}.dead", id).Bytes()
+	assert.NoError(t, err)
+	item := codecItem{}
+	assert.NoError(t, q.payload.decode(payload, &item))
+	assert.Equal(t, codecItem{ID: 1}, item)
+	assert.Equal(t, int64(0), client.Exists(ctx, q.jobsKey, q.attemptsKey).Val())
+}
+
+func TestDelayedQueueRun(t *testing.T) {
+	q, client := newTestQueue(t, &DelayedQueueCfg{PollIntervalMs: 10, Workers: 2})
+	for i := 0; i < 3; i++ {
+		_, err := q.Enqueue(context.Background(), codecItem{ID: int64(i)}, 0)
+		assert.NoError(t, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	handled := make(chan int64, 3)
+	done := make(chan error)
+	go func() {
+		done <- q.Run(ctx, func(ctx context.Context, job *Job) error {
+			item := codecItem{}
+			assert.NoError(t, job.Decode(&item))
+			handled <- item.ID
+			return nil
+		})
+	}()
+
+	ids := make([]int64, 0, 3)
+	for i := 0; i < 3; i++ {
+		ids = append(ids, <-handled)
+	}
+	cancel()
+	assert.NoError(t, <-done)
+	assert.ElementsMatch(t, []int64{0, 1, 2}, ids)
+	assert.Equal(t, int64(0), client.Exists(context.Background(), q.jobsKey).Val())
+}
+
+func TestDelayedQueueRetryBackoff(t *testing.T) {
+	q := &delayedQueue{visibility: 10 * time.Second}
+	assert.Equal(t, time.Second, q.retryBackoff(1))
+	assert.Equal(t, 2*time.Second, q.retryBackoff(2))
+	assert.Equal(t, 8*time.Second, q.retryBackoff(4))
+	assert.Equal(t, 10*time.Second, q.retryBackoff(5))
+	assert.Equal(t, 10*time.Second, q.retryBackoff(100))
+}
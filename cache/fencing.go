@@ -0,0 +1,15 @@
+package cache
+
+// fencedLock attach a fencing token to a releaser, locks are fenced when obtained WithFencing.
+// Tokens increase monotonically per lock key, so downstream writes can reject a stale holder
+// which still believes it owns an already expired lock.
+// Only the single node redis locker and the memory locker issue tokens, the redlock and db lockers
+// return ErrFencingUnsupported when WithFencing is requested.
+type fencedLock struct {
+	Releaser
+	fence int64
+}
+
+func (lock *fencedLock) FencingToken() (int64, bool) {
+	return lock.fence, true
+}
@@ -0,0 +1,298 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/rs/xid"
+	"github.com/vx416/gox/log"
+)
+
+const (
+	workerIDBits = 10
+	sequenceBits = 12
+	maxWorkerID  = 1<<workerIDBits - 1
+	maxSequence  = 1<<sequenceBits - 1
+)
+
+var (
+	// ErrNoWorkerID is returned when every worker id is leased by other processes
+	ErrNoWorkerID = errors.New("cache: no worker id available")
+	// ErrWorkerIDLost is returned when the worker id lease could not be renewed, until a new worker id is leased
+	ErrWorkerIDLost = errors.New("cache: worker id lease lost")
+
+	defaultIDEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// IDGenerator snowflake style generator of time sortable 64-bit ids,
+// ids consist of 41 bits milliseconds since epoch, 10 bits worker id and 12 bits sequence.
+// When the worker id lease is lost, ids are refused until a new worker id is leased in background.
+type IDGenerator interface {
+	NextID() (int64, error)
+	// NextString return the next id in decimal
+	NextString() (string, error)
+	// RequestID return the next id in decimal, it can be passed to middleware.RequestID.
+	// It falls back to xid and logs the error when no id can be issued, so it must not be used for sortable ids.
+	RequestID() string
+	WorkerID() int64
+	// Close stop renewing and release the worker id, later calls return the result of the first call
+	Close(ctx context.Context) error
+}
+
+// IDGeneratorCfg id generator config
+type IDGeneratorCfg struct {
+	Prefix   string    `yaml:"prefix"`
+	LeaseSec int       `yaml:"lease_sec"` // worker id lease, it is renewed every lease/3
+	Epoch    time.Time `yaml:"epoch"`     // default is 2020-01-01 UTC, it must never change once ids are issued
+}
+
+// NewIDGenerator lease a worker id from redis and construct an id generator
+func NewIDGenerator(ctx context.Context, client redis.Cmdable, cfg *IDGeneratorCfg) (IDGenerator, error) {
+	if cfg == nil {
+		cfg = &IDGeneratorCfg{}
+	}
+	prefix := cfg.Prefix
+	if prefix == "" {
+		prefix = "id_worker"
+	}
+	lease := time.Duration(cfg.LeaseSec) * time.Second
+	if lease <= 0 {
+		lease = 30 * time.Second
+	}
+	epoch := cfg.Epoch
+	if epoch.IsZero() {
+		epoch = defaultIDEpoch
+	}
+
+	g := &idGenerator{
+		client:  client,
+		prefix:  prefix,
+		lease:   lease,
+		logCtx:  log.Ctx(ctx).Attach(context.Background()),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	workerID, lock, err := g.leaseWorkerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	g.snowflake = newSnowflake(workerID, epoch)
+	g.hold(workerID, lock)
+
+	go g.maintain()
+	return g, nil
+}
+
+type idGenerator struct {
+	*snowflake
+	client  redis.Cmdable
+	prefix  string
+	lease   time.Duration
+	logCtx  context.Context
+	closing chan struct{}
+	done    chan struct{}
+
+	closeOnce sync.Once
+	closeErr  error
+
+	mu       sync.RWMutex
+	lock     Releaser
+	leaseCtx context.Context
+	cancel   context.CancelFunc
+}
+
+// leaseWorkerID try worker ids from a random offset, so concurrent starting processes rarely collide
+func (g *idGenerator) leaseWorkerID(ctx context.Context) (int64, Releaser, error) {
+	token, err := randomToken()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	offset := rand.Int63n(maxWorkerID + 1)
+	for i := int64(0); i <= maxWorkerID; i++ {
+		workerID := (offset + i) % (maxWorkerID + 1)
+		key := g.prefix + "." + strconv.FormatInt(workerID, 10)
+		ok, err := g.client.SetNX(ctx, key, token, g.lease).Result()
+		if err != nil {
+			return 0, nil, err
+		}
+		if ok {
+			return workerID, &tokenLock{client: g.client, key: key, token: token}, nil
+		}
+	}
+	return 0, nil, ErrNoWorkerID
+}
+
+// hold start renewing the lease of the worker id
+func (g *idGenerator) hold(workerID int64, lock Releaser) {
+	leaseCtx, cancel := WithWatchdog(log.Ctx(g.logCtx).Field("worker_id", workerID).Attach(g.logCtx), lock, g.lease)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.lock = lock
+	g.leaseCtx = leaseCtx
+	g.cancel = cancel
+}
+
+func (g *idGenerator) current() (Releaser, context.Context) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.lock, g.leaseCtx
+}
+
+// maintain lease a new worker id whenever the lease is lost, until the generator is closed
+func (g *idGenerator) maintain() {
+	defer close(g.done)
+
+	for {
+		_, leaseCtx := g.current()
+		select {
+		case <-g.closing:
+			return
+		case <-leaseCtx.Done():
+		}
+		log.Ctx(g.logCtx).Field("worker_id", g.WorkerID()).Warn("id generator: worker id lease lost")
+
+		// hand the lost worker id over the same way as Close, after the wall clock caught up with issued ids
+		if !g.sleep(g.ahead(time.Now())) {
+			return
+		}
+
+		retry := &expBackoff{min: 100 * time.Millisecond, max: g.lease}
+		for {
+			ctx, cancel := context.WithTimeout(g.logCtx, g.lease)
+			workerID, lock, err := g.leaseWorkerID(ctx)
+			cancel()
+			if err == nil {
+				g.setWorkerID(workerID)
+				g.hold(workerID, lock)
+				log.Ctx(g.logCtx).Field("worker_id", workerID).Info("id generator: worker id leased")
+				break
+			}
+			log.Ctx(g.logCtx).Err(err).Warn("id generator: lease worker id failed")
+			if !g.sleep(retry.NextBackoff()) {
+				return
+			}
+		}
+	}
+}
+
+// sleep wait for d, it returns false if the generator is closed meanwhile
+func (g *idGenerator) sleep(d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-g.closing:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func (g *idGenerator) NextID() (int64, error) {
+	_, leaseCtx := g.current()
+	if leaseCtx.Err() != nil {
+		return 0, ErrWorkerIDLost
+	}
+	return g.next(time.Now()), nil
+}
+
+func (g *idGenerator) NextString() (string, error) {
+	id, err := g.NextID()
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(id, 10), nil
+}
+
+func (g *idGenerator) RequestID() string {
+	id, err := g.NextString()
+	if err != nil {
+		log.Ctx(g.logCtx).Err(err).Warn("id generator: fallback to xid")
+		return xid.New().String()
+	}
+	return id
+}
+
+func (g *idGenerator) Close(ctx context.Context) error {
+	g.closeOnce.Do(func() {
+		g.closeErr = g.close(ctx)
+	})
+	return g.closeErr
+}
+
+func (g *idGenerator) close(ctx context.Context) error {
+	close(g.closing)
+	<-g.done
+
+	lock, _ := g.current()
+	g.mu.RLock()
+	g.cancel()
+	g.mu.RUnlock()
+	// ids may run ahead of the wall clock after a clock regression, keep the worker id until the clock catches up,
+	// so the next holder of the worker id can not issue the same ids
+	if wait := g.ahead(time.Now()); wait > 0 {
+		sleepCtx(ctx, wait)
+	}
+	return lock.Release(ctx)
+}
+
+// snowflake issue ids by a logical clock which never goes backward, when the wall clock regresses
+// or the sequence is exhausted the logical clock runs ahead of the wall clock until it catches up
+type snowflake struct {
+	mu       sync.Mutex
+	workerID int64
+	epoch    time.Time
+	last     int64
+	sequence int64
+}
+
+func newSnowflake(workerID int64, epoch time.Time) *snowflake {
+	return &snowflake{workerID: workerID, epoch: epoch}
+}
+
+func (s *snowflake) WorkerID() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.workerID
+}
+
+// setWorkerID switch to a new worker id, the logical clock is kept so ids keep increasing
+func (s *snowflake) setWorkerID(workerID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.workerID = workerID
+}
+
+func (s *snowflake) next(now time.Time) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ms := now.Sub(s.epoch).Milliseconds()
+	if ms > s.last {
+		s.last = ms
+		s.sequence = 0
+	} else {
+		s.sequence++
+		if s.sequence > maxSequence {
+			s.last++
+			s.sequence = 0
+		}
+	}
+	return s.last<<(workerIDBits+sequenceBits) | s.workerID<<sequenceBits | s.sequence
+}
+
+// ahead return how far the logical clock runs ahead of now
+func (s *snowflake) ahead(now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Duration(s.last-now.Sub(s.epoch).Milliseconds()) * time.Millisecond
+}
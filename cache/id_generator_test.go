@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnowflake(t *testing.T) {
+	s := newSnowflake(7, defaultIDEpoch)
+	now := time.Now()
+
+	first := s.next(now)
+	assert.Equal(t, int64(7), first>>sequenceBits&maxWorkerID)
+	assert.True(t, s.next(now) > first)
+
+	// clock regression keeps ids increasing
+	last := s.next(now)
+	regressed := s.next(now.Add(-time.Second))
+	assert.True(t, regressed > last)
+	assert.True(t, s.ahead(now.Add(-time.Second)) >= time.Second)
+
+	// exhausted sequence borrows the next millisecond
+	for i := 0; i < maxSequence+1; i++ {
+		id := s.next(now)
+		assert.True(t, id > last)
+		last = id
+	}
+	assert.True(t, s.ahead(now) > 0)
+}
+
+func TestIDGeneratorClose(t *testing.T) {
+	client := newTestRedis(t)
+	ctx := context.Background()
+	g, err := NewIDGenerator(ctx, client, &IDGeneratorCfg{Prefix: "test"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	key := "test." + strconv.FormatInt(g.WorkerID(), 10)
+	assert.Equal(t, int64(1), client.Exists(ctx, key).Val())
+
+	id, err := g.NextID()
+	assert.NoError(t, err)
+	assert.Equal(t, g.WorkerID(), id>>sequenceBits&maxWorkerID)
+
+	assert.NoError(t, g.Close(ctx))
+	assert.Equal(t, int64(0), client.Exists(ctx, key).Val())
+	// closing again neither panics nor releases the worker id again
+	assert.NoError(t, g.Close(ctx))
+	_, err = g.NextID()
+	assert.Equal(t, ErrWorkerIDLost, err)
+}
+
+func TestIDGeneratorLeaseLost(t *testing.T) {
+	client := newTestRedis(t)
+	ctx := context.Background()
+	g, err := NewIDGenerator(ctx, client, &IDGeneratorCfg{Prefix: "test", LeaseSec: 1})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer g.Close(ctx)
+	key := "test." + strconv.FormatInt(g.WorkerID(), 10)
+	token := client.Get(ctx, key).Val()
+
+	// the lease is taken away, the renewal fails and a worker id is leased again
+	assert.NoError(t, client.Del(ctx, key).Err())
+	var leased []string
+	for deadline := time.Now().Add(3 * time.Second); len(leased) == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		leased = client.Keys(ctx, "test.*").Val()
+	}
+	if !assert.Len(t, leased, 1) {
+		return
+	}
+	assert.NotEqual(t, token, client.Get(ctx, leased[0]).Val())
+	ttl := client.PTTL(ctx, leased[0]).Val()
+	assert.True(t, ttl > 0 && ttl <= time.Second)
+
+	for deadline := time.Now().Add(time.Second); leased[0] != "test."+strconv.FormatInt(g.WorkerID(), 10) && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	id, err := g.NextID()
+	assert.NoError(t, err)
+	assert.Equal(t, "test."+strconv.FormatInt(id>>sequenceBits&maxWorkerID, 10), leased[0])
+}
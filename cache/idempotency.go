@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// ErrNoLocker is returned when the redis client is constructed without a locker
+var ErrNoLocker = errors.New("cache: redis client has no locker")
+
+// IdempotentResponse response recorded for an idempotency key
+type IdempotentResponse struct {
+	Fingerprint string      `json:"fingerprint"` // hash of the request which produced the response
+	Status      int         `json:"status"`
+	Header      http.Header `json:"header"`
+	Body        []byte      `json:"body"`
+}
+
+// IdempotencyStore keep responses of idempotent requests for a window
+type IdempotencyStore interface {
+	// Lock obtain the key without waiting, ErrNotObtained means the same key is still in progress
+	Lock(ctx context.Context, key string) (Releaser, error)
+	// Get return ErrCacheMiss if no response is recorded for the key
+	Get(ctx context.Context, key string) (*IdempotentResponse, error)
+	Save(ctx context.Context, key string, resp *IdempotentResponse) error
+}
+
+// IdempotencyCfg idempotency store config
+type IdempotencyCfg struct {
+	Prefix     string `yaml:"prefix"`
+	WindowSec  int    `yaml:"window_sec"`   // how long responses are replayed, default is one day
+	LockTTLSec int    `yaml:"lock_ttl_sec"` // lease of in-progress requests, it is renewed while the request runs
+}
+
+// NewIdempotencyStore construct an idempotency store on redis, keys are locked by the client locker
+func NewIdempotencyStore(client *RedisClient, cfg *IdempotencyCfg) (IdempotencyStore, error) {
+	if client.Locker == nil {
+		return nil, ErrNoLocker
+	}
+	if cfg == nil {
+		cfg = &IdempotencyCfg{}
+	}
+	prefix := cfg.Prefix
+	if prefix == "" {
+		prefix = "idempotency"
+	}
+	window := time.Duration(cfg.WindowSec) * time.Second
+	if window <= 0 {
+		window = 24 * time.Hour
+	}
+
+	return &idempotencyStore{
+		cache:   &redisCache{client: client, prefix: prefix, payload: &payloadCodec{codec: JSONCodec}},
+		locker:  client.Locker,
+		prefix:  prefix,
+		window:  window,
+		lockTTL: time.Duration(cfg.LockTTLSec) * time.Second,
+	}, nil
+}
+
+type idempotencyStore struct {
+	cache   Cache
+	locker  Locker
+	prefix  string
+	window  time.Duration
+	lockTTL time.Duration
+}
+
+func (store *idempotencyStore) Lock(ctx context.Context, key string) (Releaser, error) {
+	return store.locker.Lock(ctx, store.prefix+"."+key, TryLock(), WithTTL(store.lockTTL))
+}
+
+func (store *idempotencyStore) Get(ctx context.Context, key string) (*IdempotentResponse, error) {
+	resp := &IdempotentResponse{}
+	if err := store.cache.Get(ctx, key, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (store *idempotencyStore) Save(ctx context.Context, key string, resp *IdempotentResponse) error {
+	return store.cache.Set(ctx, key, resp, store.window)
+}
@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vx416/gox/log"
+)
+
+// LeaderElector elect one leader among replicas which campaign for the same key
+type LeaderElector interface {
+	// Campaign keep campaigning until ctx is done, it steps down and returns ctx.Err() on cancellation
+	Campaign(ctx context.Context) error
+	IsLeader() bool
+}
+
+// LeaderCfg leader elector config
+type LeaderCfg struct {
+	Key              string `yaml:"key"`
+	LeaseSec         int    `yaml:"lease_sec"`          // leadership lease, it is renewed every lease/3 while leading
+	RetryIntervalSec int    `yaml:"retry_interval_sec"` // interval of campaigning when another replica leads
+
+	// OnElected is called when leadership is obtained, ctx is cancelled as soon as leadership is lost.
+	// Leader-only work should run until ctx is done, the elector waits for it before campaigning again.
+	OnElected func(ctx context.Context) `yaml:"-"`
+	// OnRevoked is called after leadership is lost and OnElected returned
+	OnRevoked func() `yaml:"-"`
+}
+
+// NewLeaderElector construct a leader elector campaigning through locker
+func NewLeaderElector(locker Locker, cfg *LeaderCfg) LeaderElector {
+	lease := time.Duration(cfg.LeaseSec) * time.Second
+	if lease <= 0 {
+		lease = 15 * time.Second
+	}
+	retryInterval := time.Duration(cfg.RetryIntervalSec) * time.Second
+	if retryInterval <= 0 {
+		retryInterval = lease / 3
+	}
+
+	return &leaderElector{
+		locker:        locker,
+		key:           "leader." + cfg.Key,
+		lease:         lease,
+		retryInterval: retryInterval,
+		onElected:     cfg.OnElected,
+		onRevoked:     cfg.OnRevoked,
+	}
+}
+
+type leaderElector struct {
+	locker        Locker
+	key           string
+	lease         time.Duration
+	retryInterval time.Duration
+	onElected     func(ctx context.Context)
+	onRevoked     func()
+	leader        int32
+}
+
+func (e *leaderElector) IsLeader() bool {
+	return atomic.LoadInt32(&e.leader) == 1
+}
+
+func (e *leaderElector) Campaign(ctx context.Context) error {
+	logger := log.Ctx(ctx).Field("leader_key", e.key)
+
+	for {
+		lock, err := e.locker.Lock(ctx, e.key, TryLock(), WithTTL(e.lease))
+		if err == nil {
+			e.lead(logger.Attach(ctx), lock)
+		} else if !errors.Is(err, ErrNotObtained) && ctx.Err() == nil {
+			logger.Err(err).Warn("leader elector: campaign failed")
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		sleepCtx(ctx, e.retryInterval)
+	}
+}
+
+// lead hold leadership until the lease cannot be renewed or ctx is done
+func (e *leaderElector) lead(ctx context.Context, lock Releaser) {
+	leaderCtx, cancel := WithWatchdog(ctx, lock, e.lease)
+	atomic.StoreInt32(&e.leader, 1)
+	log.Ctx(ctx).Info("leader elector: elected")
+
+	var wg sync.WaitGroup
+	if e.onElected != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.onElected(leaderCtx)
+		}()
+	}
+
+	<-leaderCtx.Done()
+	atomic.StoreInt32(&e.leader, 0)
+	wg.Wait()
+	cancel()
+
+	releaseCtx, releaseCancel := context.WithTimeout(context.Background(), e.lease)
+	defer releaseCancel()
+	if err := lock.Release(releaseCtx); err != nil && !errors.Is(err, ErrLockNotHeld) {
+		log.Ctx(ctx).Err(err).Warn("leader elector: release leadership failed")
+	}
+	log.Ctx(ctx).Info("leader elector: revoked")
+
+	if e.onRevoked != nil {
+		e.onRevoked()
+	}
+}
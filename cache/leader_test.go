@@ -0,0 +1,49 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaderElector(t *testing.T) {
+	locker := NewMemoryLocker("test", time.Second)
+	elected := make(chan string, 2)
+	revoked := make(chan string, 2)
+	newElector := func(name string) LeaderElector {
+		return NewLeaderElector(locker, &LeaderCfg{
+			Key:       "job",
+			LeaseSec:  1,
+			OnElected: func(ctx context.Context) { elected <- name; <-ctx.Done() },
+			OnRevoked: func() { revoked <- name },
+		})
+	}
+
+	first, second := newElector("first"), newElector("second")
+	firstCtx, firstCancel := context.WithCancel(context.Background())
+	firstDone := make(chan error)
+	go func() { firstDone <- first.Campaign(firstCtx) }()
+	assert.Equal(t, "first", <-elected)
+	assert.True(t, first.IsLeader())
+
+	secondCtx, secondCancel := context.WithCancel(context.Background())
+	defer secondCancel()
+	go func() { _ = second.Campaign(secondCtx) }()
+	time.Sleep(500 * time.Millisecond)
+	assert.False(t, second.IsLeader())
+
+	firstCancel()
+	assert.Equal(t, context.Canceled, <-firstDone)
+	assert.Equal(t, "first", <-revoked)
+	assert.False(t, first.IsLeader())
+
+	select {
+	case name := <-elected:
+		assert.Equal(t, "second", name)
+	case <-time.After(2 * time.Second):
+		t.Fatal("second elector is not elected")
+	}
+	assert.True(t, second.IsLeader())
+}
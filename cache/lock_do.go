@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vx416/gox/log"
+)
+
+// releaseTimeout bound releasing a lock after the critical section, it is independent of the caller context
+const releaseTimeout = 5 * time.Second
+
+// doWithLock run fn while holding the lock of key, it is shared by every Locker implementation.
+// fn's context is cancelled when the lease can not be renewed, the lock is released when fn returns or panics.
+// ErrNotObtained is returned as is, other acquisition errors are wrapped. fn is not called when the lease
+// is already gone after acquisition, ErrLockNotHeld is returned instead.
+func doWithLock(ctx context.Context, locker Locker, key string, fn func(ctx context.Context) error, opts ...LockOption) error {
+	logger := log.Ctx(ctx).Field("lock_key", key)
+
+	start := time.Now()
+	lock, err := locker.Lock(ctx, key, opts...)
+	wait := time.Since(start)
+	if err != nil {
+		if errors.Is(err, ErrNotObtained) {
+			logger.Field("wait_ms", wait.Milliseconds()).Debug("lock: not obtained")
+			return err
+		}
+		return fmt.Errorf("cache: obtain lock %s: %w", key, err)
+	}
+
+	obtained := time.Now()
+	// the ttl is only known when it is set by options, otherwise the watchdog asks the lock for its remaining lease
+	watchCtx, cancel := WithWatchdog(ctx, lock, newLockOptions(0, opts...).ttl)
+	defer func() {
+		cancel()
+		releaseCtx, releaseCancel := context.WithTimeout(logger.Attach(context.Background()), releaseTimeout)
+		defer releaseCancel()
+		if releaseErr := lock.Release(releaseCtx); releaseErr != nil {
+			logger.Err(releaseErr).Warn("lock: release failed")
+		}
+		logger.Field("wait_ms", wait.Milliseconds()).
+			Field("hold_ms", time.Since(obtained).Milliseconds()).
+			Debug("lock: released")
+	}()
+
+	if watchCtx.Err() != nil {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return fmt.Errorf("cache: lock %s: %w", key, ErrLockNotHeld)
+	}
+	return fn(watchCtx)
+}
@@ -0,0 +1,208 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/bsm/redislock"
+)
+
+var (
+	// ErrNotObtained is returned when a lock cannot be obtained before giving up
+	ErrNotObtained = redislock.ErrNotObtained
+	// ErrLockNotHeld is returned when releasing or refreshing a lock which is not held anymore
+	ErrLockNotHeld = redislock.ErrLockNotHeld
+	// ErrFencingUnsupported is returned when WithFencing is requested from a locker which does not issue tokens
+	ErrFencingUnsupported = errors.New("cache: locker does not issue fencing tokens")
+)
+
+const (
+	defaultLockMinBackoff = 10 * time.Millisecond
+	defaultLockMaxBackoff = 300 * time.Millisecond
+	defaultLockMaxWait    = time.Second
+)
+
+// RetryStrategy decide how long to wait before next attempt, a non-positive backoff stops retrying
+type RetryStrategy interface {
+	NextBackoff() time.Duration
+}
+
+// LockOption customize a single lock acquisition
+type LockOption func(opts *lockOptions)
+
+type lockOptions struct {
+	ttl      time.Duration
+	maxWait  time.Duration
+	newRetry func() RetryStrategy
+	fencing  bool
+}
+
+// TryLock try to obtain the lock once without waiting
+func TryLock() LockOption {
+	return func(opts *lockOptions) {
+		opts.newRetry = func() RetryStrategy { return noRetry{} }
+	}
+}
+
+// WithTTL set lock ttl instead of the locker default
+func WithTTL(ttl time.Duration) LockOption {
+	return func(opts *lockOptions) {
+		if ttl > 0 {
+			opts.ttl = ttl
+		}
+	}
+}
+
+// WithFencing issue a fencing token with the lock, see Releaser.FencingToken.
+// The counter of the key is kept forever, so only use it for a bounded set of keys.
+func WithFencing() LockOption {
+	return func(opts *lockOptions) {
+		opts.fencing = true
+	}
+}
+
+// WithMaxWait retry at most d, default is one second
+func WithMaxWait(d time.Duration) LockOption {
+	return func(opts *lockOptions) {
+		opts.maxWait = d
+	}
+}
+
+// WaitForContext keep retrying until ctx is done, it retries forever if ctx has no deadline
+func WaitForContext() LockOption {
+	return func(opts *lockOptions) {
+		opts.maxWait = 0
+	}
+}
+
+// WithLinearBackoff retry every interval with jitter
+func WithLinearBackoff(interval time.Duration) LockOption {
+	return func(opts *lockOptions) {
+		opts.newRetry = func() RetryStrategy { return &linearBackoff{interval: interval} }
+	}
+}
+
+// WithExpBackoff retry with exponential backoff between min and max with jitter
+func WithExpBackoff(min, max time.Duration) LockOption {
+	return func(opts *lockOptions) {
+		opts.newRetry = func() RetryStrategy { return &expBackoff{min: min, max: max} }
+	}
+}
+
+// WithRetryStrategy use custom retry strategy, newRetry is called once per acquisition
+func WithRetryStrategy(newRetry func() RetryStrategy) LockOption {
+	return func(opts *lockOptions) {
+		opts.newRetry = newRetry
+	}
+}
+
+func newLockOptions(defaultTTL time.Duration, opts ...LockOption) *lockOptions {
+	o := &lockOptions{
+		ttl:     defaultTTL,
+		maxWait: defaultLockMaxWait,
+		newRetry: func() RetryStrategy {
+			return &expBackoff{min: defaultLockMinBackoff, max: defaultLockMaxBackoff}
+		},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// obtain call try until it succeeds or the retry strategy, wait bound or ctx gives up.
+// Every call owns its retry state, so concurrent acquisitions never share backoff.
+func (o *lockOptions) obtain(ctx context.Context, try func(ctx context.Context) (bool, error)) error {
+	waitCtx := ctx
+	if o.maxWait > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, o.maxWait)
+		defer cancel()
+	}
+
+	retry := o.newRetry()
+	var timer *time.Timer
+	for {
+		ok, err := try(waitCtx)
+		if ok {
+			return nil
+		}
+		if err != nil && waitCtx.Err() == nil {
+			return err
+		}
+
+		backoff := time.Duration(0)
+		if waitCtx.Err() == nil {
+			backoff = retry.NextBackoff()
+		}
+		if backoff <= 0 {
+			return o.giveUp(ctx)
+		}
+
+		if timer == nil {
+			timer = time.NewTimer(backoff)
+			defer timer.Stop()
+		} else {
+			timer.Reset(backoff)
+		}
+
+		select {
+		case <-waitCtx.Done():
+			return o.giveUp(ctx)
+		case <-timer.C:
+		}
+	}
+}
+
+// giveUp return the caller cancellation as is, otherwise the lock is simply not obtained
+func (o *lockOptions) giveUp(ctx context.Context) error {
+	if ctx.Err() == context.Canceled {
+		return ctx.Err()
+	}
+	return ErrNotObtained
+}
+
+type noRetry struct{}
+
+func (noRetry) NextBackoff() time.Duration {
+	return 0
+}
+
+type linearBackoff struct {
+	interval time.Duration
+}
+
+func (b *linearBackoff) NextBackoff() time.Duration {
+	return jitter(b.interval)
+}
+
+type expBackoff struct {
+	min, max time.Duration
+	next     time.Duration
+}
+
+func (b *expBackoff) NextBackoff() time.Duration {
+	if b.next <= 0 {
+		b.next = b.min
+	}
+	if b.next <= 0 {
+		b.next = time.Millisecond
+	}
+	d := b.next
+	if b.max > 0 && d > b.max {
+		d = b.max
+	}
+	b.next = d * 2
+	return jitter(d)
+}
+
+// jitter spread d over [d/2, d] so that waiters do not retry in lockstep
+func jitter(d time.Duration) time.Duration {
+	half := int64(d / 2)
+	if half <= 0 {
+		return d
+	}
+	return time.Duration(half + rand.Int63n(half+1))
+}
@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockRetryStatePerCall(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			o := newLockOptions(time.Second, WithExpBackoff(10*time.Millisecond, 80*time.Millisecond))
+			retry := o.newRetry()
+			expected := 10 * time.Millisecond
+			for j := 0; j < 6; j++ {
+				backoff := retry.NextBackoff()
+				assert.True(t, backoff >= expected/2 && backoff <= expected, "backoff %s out of range %s", backoff, expected)
+				if expected < 80*time.Millisecond {
+					expected *= 2
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestLockObtainConcurrent(t *testing.T) {
+	var (
+		held     int32
+		acquired int32
+		wg       sync.WaitGroup
+	)
+	try := func(ctx context.Context) (bool, error) {
+		return atomic.CompareAndSwapInt32(&held, 0, 1), nil
+	}
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			o := newLockOptions(time.Second, WaitForContext(), WithLinearBackoff(time.Millisecond))
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if !assert.NoError(t, o.obtain(ctx, try)) {
+				return
+			}
+			atomic.AddInt32(&acquired, 1)
+			time.Sleep(time.Millisecond)
+			assert.True(t, atomic.CompareAndSwapInt32(&held, 1, 0))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(20), acquired)
+}
+
+func TestLockObtainGiveUp(t *testing.T) {
+	try := func(ctx context.Context) (bool, error) {
+		return false, nil
+	}
+
+	o := newLockOptions(time.Second, TryLock())
+	assert.Equal(t, ErrNotObtained, o.obtain(context.Background(), try))
+
+	o = newLockOptions(time.Second, WithMaxWait(20*time.Millisecond), WithLinearBackoff(time.Millisecond))
+	start := time.Now()
+	assert.Equal(t, ErrNotObtained, o.obtain(context.Background(), try))
+	assert.True(t, time.Since(start) >= 20*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	o = newLockOptions(time.Second, WaitForContext())
+	assert.Equal(t, context.Canceled, o.obtain(ctx, try))
+}
+
+type expiredLock struct{ released bool }
+
+func (l *expiredLock) Lock(ctx context.Context, key string, opts ...LockOption) (Releaser, error) {
+	return l, nil
+}
+
+func (l *expiredLock) Do(ctx context.Context, key string, fn func(ctx context.Context) error, opts ...LockOption) error {
+	return doWithLock(ctx, l, key, fn, opts...)
+}
+
+func (l *expiredLock) Release(ctx context.Context) error { l.released = true; return nil }
+
+func (l *expiredLock) Refresh(ctx context.Context, ttl time.Duration) error { return ErrLockNotHeld }
+
+func (l *expiredLock) TTL(ctx context.Context) (time.Duration, error) { return 0, nil }
+
+func (l *expiredLock) FencingToken() (int64, bool) { return 0, false }
+
+func TestDoWithLockExpiredLease(t *testing.T) {
+	locker := &expiredLock{}
+	called := false
+	err := locker.Do(context.Background(), "key", func(ctx context.Context) error {
+		called = true
+		return nil
+	})
+	assert.True(t, errors.Is(err, ErrLockNotHeld))
+	assert.False(t, called)
+	assert.True(t, locker.released)
+}
@@ -0,0 +1,57 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/vx416/gox/log"
+)
+
+// WithWatchdog keep extending the lock lease in background while ctx is alive.
+// The lease is renewed to ttl every ttl/3, if ttl is not positive the current remaining lease is used.
+// The returned context is cancelled when a renewal fails, so the holder should stop its work.
+// Call the cancel function to stop the watchdog before releasing the lock.
+func WithWatchdog(ctx context.Context, releaser Releaser, ttl time.Duration) (context.Context, context.CancelFunc) {
+	watchCtx, cancel := context.WithCancel(ctx)
+
+	if ttl <= 0 {
+		remain, err := releaser.TTL(ctx)
+		if err != nil || remain <= 0 {
+			log.Ctx(ctx).Warn("lock watchdog: lock already expired")
+			cancel()
+			return watchCtx, cancel
+		}
+		ttl = remain
+	}
+
+	go watch(watchCtx, cancel, releaser, ttl)
+	return watchCtx, cancel
+}
+
+func watch(ctx context.Context, cancel context.CancelFunc, releaser Releaser, ttl time.Duration) {
+	defer cancel()
+
+	interval := ttl / 3
+	if interval <= 0 {
+		interval = ttl
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			refreshCtx, refreshCancel := context.WithTimeout(ctx, interval)
+			err := releaser.Refresh(refreshCtx, ttl)
+			refreshCancel()
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Ctx(ctx).Err(err).Warn("lock watchdog: renew lease failed")
+				}
+				return
+			}
+		}
+	}
+}
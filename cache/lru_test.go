@@ -0,0 +1,37 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRUEviction(t *testing.T) {
+	c := newLRU(2)
+	c.Set("a", []byte("1"), time.Minute)
+	c.Set("b", []byte("2"), time.Minute)
+	_, ok := c.Get("a")
+	assert.True(t, ok)
+
+	c.Set("c", []byte("3"), time.Minute)
+	assert.Equal(t, 2, c.Len())
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+	val, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("1"), val)
+
+	c.Remove("a")
+	_, ok = c.Get("a")
+	assert.False(t, ok)
+}
+
+func TestLRUExpire(t *testing.T) {
+	c := newLRU(2)
+	c.Set("a", []byte("1"), 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	_, ok := c.Get("a")
+	assert.False(t, ok)
+	assert.Equal(t, 0, c.Len())
+}
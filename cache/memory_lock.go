@@ -0,0 +1,143 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// NewMemoryLocker construct an in-process locker, it behaves like the redis locker
+// and can replace it in unit tests and single instance deployments
+func NewMemoryLocker(prefix string, defaultTTL time.Duration) Locker {
+	if defaultTTL <= 0 {
+		defaultTTL = time.Second
+	}
+
+	return &memoryLocker{
+		prefix:     prefix,
+		defaultTTL: defaultTTL,
+		locks:      make(map[string]*memoryEntry),
+		fences:     make(map[string]int64),
+	}
+}
+
+type memoryLocker struct {
+	mu         sync.Mutex
+	prefix     string
+	defaultTTL time.Duration
+	locks      map[string]*memoryEntry
+	fences     map[string]int64
+}
+
+type memoryEntry struct {
+	token    string
+	expireAt time.Time
+}
+
+// Do run fn while holding the lock of key
+func (locker *memoryLocker) Do(ctx context.Context, key string, fn func(ctx context.Context) error, opts ...LockOption) error {
+	return doWithLock(ctx, locker, key, fn, opts...)
+}
+
+// Lock obtain the lock, acquisition options are the same as the redis locker
+func (locker *memoryLocker) Lock(ctx context.Context, key string, opts ...LockOption) (Releaser, error) {
+	o := newLockOptions(locker.defaultTTL, opts...)
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	lock := &memoryLock{
+		locker: locker,
+		key:    locker.prefix + "." + key,
+		token:  token,
+	}
+
+	var fence int64
+	err = o.obtain(ctx, func(ctx context.Context) (bool, error) {
+		var ok bool
+		fence, ok = locker.obtain(lock.key, token, o.ttl, o.fencing)
+		return ok, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if o.fencing {
+		return &fencedLock{Releaser: lock, fence: fence}, nil
+	}
+	return lock, nil
+}
+
+// obtain set the lock and return its fencing token if fencing is requested
+func (locker *memoryLocker) obtain(key, token string, ttl time.Duration, fencing bool) (int64, bool) {
+	locker.mu.Lock()
+	defer locker.mu.Unlock()
+
+	now := time.Now()
+	if entry, ok := locker.locks[key]; ok && now.Before(entry.expireAt) {
+		return 0, false
+	}
+	locker.locks[key] = &memoryEntry{token: token, expireAt: now.Add(ttl)}
+	if !fencing {
+		return 0, true
+	}
+	locker.fences[key]++
+	return locker.fences[key], true
+}
+
+// held return the entry if it is still owned by token
+func (locker *memoryLocker) held(key, token string) (*memoryEntry, bool) {
+	entry, ok := locker.locks[key]
+	if !ok || entry.token != token {
+		return nil, false
+	}
+	if !time.Now().Before(entry.expireAt) {
+		delete(locker.locks, key)
+		return nil, false
+	}
+	return entry, true
+}
+
+type memoryLock struct {
+	locker *memoryLocker
+	key    string
+	token  string
+}
+
+func (lock *memoryLock) Release(ctx context.Context) error {
+	lock.locker.mu.Lock()
+	defer lock.locker.mu.Unlock()
+
+	if _, ok := lock.locker.held(lock.key, lock.token); !ok {
+		return ErrLockNotHeld
+	}
+	delete(lock.locker.locks, lock.key)
+	return nil
+}
+
+func (lock *memoryLock) Refresh(ctx context.Context, ttl time.Duration) error {
+	lock.locker.mu.Lock()
+	defer lock.locker.mu.Unlock()
+
+	entry, ok := lock.locker.held(lock.key, lock.token)
+	if !ok {
+		return ErrLockNotHeld
+	}
+	entry.expireAt = time.Now().Add(ttl)
+	return nil
+}
+
+func (lock *memoryLock) FencingToken() (int64, bool) {
+	return 0, false
+}
+
+func (lock *memoryLock) TTL(ctx context.Context) (time.Duration, error) {
+	lock.locker.mu.Lock()
+	defer lock.locker.mu.Unlock()
+
+	entry, ok := lock.locker.held(lock.key, lock.token)
+	if !ok {
+		return 0, nil
+	}
+	return time.Until(entry.expireAt), nil
+}
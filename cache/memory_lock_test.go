@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryLocker(t *testing.T) {
+	ctx := context.Background()
+	locker := NewMemoryLocker("test", time.Second)
+
+	lock, err := locker.Lock(ctx, "key")
+	assert.NoError(t, err)
+
+	_, err = locker.Lock(ctx, "key", TryLock())
+	assert.Equal(t, ErrNotObtained, err)
+
+	ttl, err := lock.TTL(ctx)
+	assert.NoError(t, err)
+	assert.True(t, ttl > 0 && ttl <= time.Second)
+
+	assert.NoError(t, lock.Refresh(ctx, 2*time.Second))
+	ttl, err = lock.TTL(ctx)
+	assert.NoError(t, err)
+	assert.True(t, ttl > time.Second)
+
+	assert.NoError(t, lock.Release(ctx))
+	assert.Equal(t, ErrLockNotHeld, lock.Release(ctx))
+
+	_, ok := lock.FencingToken()
+	assert.False(t, ok)
+
+	lock, err = locker.Lock(ctx, "key", TryLock(), WithFencing())
+	assert.NoError(t, err)
+	fence, ok := lock.FencingToken()
+	assert.True(t, ok)
+	assert.NoError(t, lock.Release(ctx))
+	lock, err = locker.Lock(ctx, "key", TryLock(), WithFencing())
+	assert.NoError(t, err)
+	nextFence, _ := lock.FencingToken()
+	assert.True(t, nextFence > fence)
+	assert.NoError(t, lock.Release(ctx))
+}
+
+func TestMemoryLockerExpire(t *testing.T) {
+	ctx := context.Background()
+	locker := NewMemoryLocker("test", time.Second)
+
+	lock, err := locker.Lock(ctx, "key", WithTTL(20*time.Millisecond))
+	assert.NoError(t, err)
+
+	lock2, err := locker.Lock(ctx, "key", WithLinearBackoff(5*time.Millisecond))
+	assert.NoError(t, err)
+	assert.Equal(t, ErrLockNotHeld, lock.Release(ctx))
+	assert.Equal(t, ErrLockNotHeld, lock.Refresh(ctx, time.Second))
+	assert.NoError(t, lock2.Release(ctx))
+
+	lock, err = locker.Lock(ctx, "key")
+	assert.NoError(t, err)
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = locker.Lock(cancelCtx, "key", WaitForContext())
+	assert.Equal(t, context.Canceled, err)
+	assert.NoError(t, lock.Release(ctx))
+}
+
+func TestLockerDo(t *testing.T) {
+	ctx := context.Background()
+	locker := NewMemoryLocker("test", time.Second)
+
+	err := locker.Do(ctx, "key", func(ctx context.Context) error {
+		_, err := locker.Lock(ctx, "key", TryLock())
+		assert.Equal(t, ErrNotObtained, err)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	assert.Panics(t, func() {
+		_ = locker.Do(ctx, "key", func(ctx context.Context) error { panic("boom") })
+	})
+	lock, err := locker.Lock(ctx, "key", TryLock())
+	assert.NoError(t, err)
+
+	err = locker.Do(ctx, "key", func(ctx context.Context) error { return nil }, TryLock())
+	assert.True(t, errors.Is(err, ErrNotObtained))
+	assert.NoError(t, lock.Release(ctx))
+}
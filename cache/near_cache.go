@@ -0,0 +1,261 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/rs/xid"
+)
+
+// NearCache two-tier cache which keeps hot keys in process memory in front of redis.
+// Writes and deletes are broadcast over redis pub/sub, so every instance drops its stale local entries.
+type NearCache interface {
+	Cache
+	Close() error
+}
+
+// NearCacheCfg near cache config
+type NearCacheCfg struct {
+	CacheCfg
+	Capacity    int    `yaml:"capacity"`      // max local entries, default is 10000
+	LocalTTLSec int    `yaml:"local_ttl_sec"` // upper bound of local entry lifetime, also bounds staleness when an invalidation is lost
+	Channel     string `yaml:"channel"`
+}
+
+// invalidation pub/sub message
+type invalidation struct {
+	Origin string   `json:"origin"`
+	Keys   []string `json:"keys"`
+}
+
+// NewNearCache construct a near cache and subscribe the invalidation channel
+func NewNearCache(client *RedisClient, cfg *NearCacheCfg) (NearCache, error) {
+	if cfg == nil {
+		cfg = &NearCacheCfg{}
+	}
+	capacity := cfg.Capacity
+	if capacity <= 0 {
+		capacity = 10000
+	}
+	localTTL := time.Duration(cfg.LocalTTLSec) * time.Second
+	if localTTL <= 0 {
+		localTTL = time.Minute
+	}
+	channel := cfg.Channel
+	if channel == "" {
+		channel = "gox.cache.invalidate"
+		if cfg.Prefix != "" {
+			channel = cfg.Prefix + ".invalidate"
+		}
+	}
+
+	remote, err := newRedisCache(client, &cfg.CacheCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	pubsub := client.Subscribe(ctx, channel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	c := &nearCache{
+		remote:   remote,
+		local:    newLRU(capacity),
+		localTTL: localTTL,
+		channel:  channel,
+		id:       xid.New().String(),
+		pubsub:   pubsub,
+		done:     make(chan struct{}),
+	}
+	go c.listen()
+
+	return c, nil
+}
+
+type nearCache struct {
+	remote   *redisCache
+	local    *lruCache
+	localTTL time.Duration
+	channel  string
+	id       string
+	pubsub   *redis.PubSub
+	done     chan struct{}
+
+	// mu guard gen, gen is increased on every local write and invalidation,
+	// so values read from redis before an invalidation are not kept locally
+	mu  sync.Mutex
+	gen uint64
+}
+
+// Get get value from local cache first, then from redis
+func (c *nearCache) Get(ctx context.Context, key string, dst interface{}) error {
+	if data, ok := c.local.Get(key); ok {
+		return c.remote.decode(data, dst)
+	}
+
+	gen := c.generation()
+	data, remain, err := c.remote.getBytesWithTTL(ctx, key)
+	if err != nil {
+		return err
+	}
+	c.setLocal(key, data, remain, gen)
+	return c.remote.decode(data, dst)
+}
+
+// Set set value into redis and local cache, other instances are notified to drop the key
+func (c *nearCache) Set(ctx context.Context, key string, val interface{}, ttl time.Duration) error {
+	data, err := c.remote.encode(val)
+	if err != nil {
+		return err
+	}
+	if err := c.remote.setBytes(ctx, key, data, ttl); err != nil {
+		return err
+	}
+	c.write(key, data, ttl)
+	return c.publish(ctx, key)
+}
+
+// Delete delete keys from redis and local cache, other instances are notified to drop the keys
+func (c *nearCache) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	c.invalidate(keys...)
+	if err := c.remote.Delete(ctx, keys...); err != nil {
+		return err
+	}
+	return c.publish(ctx, keys...)
+}
+
+// SetWithTags set value into redis and local cache, other instances are notified to drop the key
+func (c *nearCache) SetWithTags(ctx context.Context, key string, val interface{}, ttl time.Duration, tags ...string) error {
+	data, err := c.remote.encode(val)
+	if err != nil {
+		return err
+	}
+	if err := c.remote.setBytesWithTags(ctx, key, data, ttl, tags...); err != nil {
+		return err
+	}
+	c.write(key, data, ttl)
+	return c.publish(ctx, key)
+}
+
+// InvalidateTags delete keys of the tag groups from redis and every instance
+func (c *nearCache) InvalidateTags(ctx context.Context, tags ...string) error {
+	keys, err := c.remote.invalidateTags(ctx, tags...)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	c.invalidate(keys...)
+	return c.publish(ctx, keys...)
+}
+
+// GetOrLoad get value from local cache first, then fallback to redis cache-aside loading
+func (c *nearCache) GetOrLoad(ctx context.Context, key string, ttl time.Duration, loader Loader, dst interface{}) error {
+	if data, ok := c.local.Get(key); ok {
+		return c.remote.decode(data, dst)
+	}
+
+	gen := c.generation()
+	data, remain, err := c.remote.getBytesWithTTL(ctx, key)
+	if errors.Is(err, ErrCacheMiss) {
+		data, err = c.remote.getOrLoadBytes(ctx, key, ttl, loader)
+		remain = ttl
+	}
+	if err != nil {
+		return err
+	}
+	c.setLocal(key, data, remain, gen)
+	return c.remote.decode(data, dst)
+}
+
+// Close stop listening invalidation and drop all local entries
+func (c *nearCache) Close() error {
+	err := c.pubsub.Close()
+	<-c.done
+	c.local.Purge()
+	return err
+}
+
+func (c *nearCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// setLocal keep data read from redis in local cache for at most the remaining ttl of the redis entry,
+// unless it was invalidated since gen. Negative results follow the negative ttl and are not kept if it is disabled.
+func (c *nearCache) setLocal(key string, data []byte, remain time.Duration, gen uint64) {
+	if bytes.Equal(data, negativeValue) {
+		if c.remote.negativeTTL <= 0 {
+			return
+		}
+		if remain <= 0 || remain > c.remote.negativeTTL {
+			remain = c.remote.negativeTTL
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gen != gen {
+		return
+	}
+	c.local.Set(key, data, c.ttl(remain))
+}
+
+// write keep data written by this instance in local cache
+func (c *nearCache) write(key string, data []byte, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	c.local.Set(key, data, c.ttl(ttl))
+}
+
+func (c *nearCache) invalidate(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	c.local.Remove(keys...)
+}
+
+// ttl local entry should not outlive the redis entry
+func (c *nearCache) ttl(ttl time.Duration) time.Duration {
+	if ttl > 0 && ttl < c.localTTL {
+		return ttl
+	}
+	return c.localTTL
+}
+
+func (c *nearCache) publish(ctx context.Context, keys ...string) error {
+	msg, err := json.Marshal(invalidation{Origin: c.id, Keys: keys})
+	if err != nil {
+		return err
+	}
+	return c.remote.client.Publish(ctx, c.channel, msg).Err()
+}
+
+func (c *nearCache) listen() {
+	defer close(c.done)
+
+	for msg := range c.pubsub.Channel() {
+		inv := invalidation{}
+		if err := json.Unmarshal([]byte(msg.Payload), &inv); err != nil {
+			continue
+		}
+		if inv.Origin == c.id {
+			continue
+		}
+		c.invalidate(inv.Keys...)
+	}
+}
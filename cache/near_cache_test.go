@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNearCacheSetLocal(t *testing.T) {
+	c := &nearCache{remote: &redisCache{}, local: newLRU(10), localTTL: time.Minute}
+
+	c.setLocal("missing", negativeValue, time.Minute, c.generation())
+	_, ok := c.local.Get("missing")
+	assert.False(t, ok)
+
+	c.remote.negativeTTL = 50 * time.Millisecond
+	c.setLocal("missing", negativeValue, time.Minute, c.generation())
+	_, ok = c.local.Get("missing")
+	assert.True(t, ok)
+	time.Sleep(60 * time.Millisecond)
+	_, ok = c.local.Get("missing")
+	assert.False(t, ok)
+
+	c.setLocal("key", []byte("val"), 0, c.generation())
+	data, ok := c.local.Get("key")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("val"), data)
+
+	// the local entry does not outlive the redis entry
+	c.setLocal("short", []byte("val"), 50*time.Millisecond, c.generation())
+	time.Sleep(60 * time.Millisecond)
+	_, ok = c.local.Get("short")
+	assert.False(t, ok)
+
+	// a value read before an invalidation is not kept
+	gen := c.generation()
+	c.invalidate("stale")
+	c.setLocal("stale", []byte("val"), 0, gen)
+	_, ok = c.local.Get("stale")
+	assert.False(t, ok)
+}
+
+func TestNearCacheInvalidation(t *testing.T) {
+	client := newTestRedis(t)
+	ctx := context.Background()
+	cfg := &NearCacheCfg{CacheCfg: CacheCfg{Prefix: "near"}}
+
+	first, err := NewNearCache(client, cfg)
+	assert.NoError(t, err)
+	defer first.Close()
+	second, err := NewNearCache(client, cfg)
+	assert.NoError(t, err)
+	defer second.Close()
+
+	item := codecItem{}
+	assert.NoError(t, first.Set(ctx, "item", codecItem{ID: 1}, time.Minute))
+	assert.NoError(t, second.Get(ctx, "item", &item))
+	assert.Equal(t, codecItem{ID: 1}, item)
+
+	// the local copy of second is dropped once first publishes the write
+	assert.NoError(t, first.Set(ctx, "item", codecItem{ID: 2}, time.Minute))
+	assert.Eventually(t, func() bool {
+		return second.Get(ctx, "item", &item) == nil && item.ID == 2
+	}, time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, first.Delete(ctx, "item"))
+	assert.Eventually(t, func() bool {
+		return second.Get(ctx, "item", &item) == ErrCacheMiss
+	}, time.Second, 10*time.Millisecond)
+
+	// local entries follow the remaining ttl of the redis entry, which expires without invalidation
+	assert.NoError(t, client.Set(ctx, "near.short", mustEncode(t, second, codecItem{ID: 3}), 200*time.Millisecond).Err())
+	assert.NoError(t, second.Get(ctx, "short", &item))
+	assert.Equal(t, codecItem{ID: 3}, item)
+	_, ok := second.(*nearCache).local.Get("short")
+	assert.True(t, ok)
+	time.Sleep(250 * time.Millisecond)
+	_, ok = second.(*nearCache).local.Get("short")
+	assert.False(t, ok)
+}
+
+func mustEncode(t *testing.T, c NearCache, val interface{}) []byte {
+	data, err := c.(*nearCache).remote.encode(val)
+	assert.NoError(t, err)
+	return data
+}
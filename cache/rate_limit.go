@@ -0,0 +1,150 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// both algorithms work in redis server time with microsecond precision,
+// numbers are formatted explicitly because lua would print them in scientific notation
+var (
+	luaSlidingWindow = redis.NewScript(`
+redis.replicate_commands()
+local t = redis.call("time")
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+redis.call("zremrangebyscore", KEYS[1], "-inf", string.format("%.0f", now - window))
+local count = redis.call("zcard", KEYS[1])
+if count < limit then
+	redis.call("zadd", KEYS[1], string.format("%.0f", now), string.format("%.0f", now) .. "-" .. ARGV[3])
+	redis.call("pexpire", KEYS[1], string.format("%.0f", math.ceil(window / 1000)))
+	local oldest = redis.call("zrange", KEYS[1], 0, 0, "WITHSCORES")
+	return {1, limit - count - 1, 0, tonumber(oldest[2]) + window - now}
+end
+local oldest = redis.call("zrange", KEYS[1], 0, 0, "WITHSCORES")
+local retry = tonumber(oldest[2]) + window - now
+return {0, 0, retry, retry}`)
+	luaGCRA = redis.NewScript(`
+redis.replicate_commands()
+local t = redis.call("time")
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local emission = tonumber(ARGV[1])
+local burstOffset = tonumber(ARGV[2])
+local tat = tonumber(redis.call("get", KEYS[1]) or now)
+if tat < now then
+	tat = now
+end
+local newTat = tat + emission
+local diff = now - (newTat - burstOffset)
+if diff < 0 then
+	return {0, 0, -diff, tat - now}
+end
+redis.call("set", KEYS[1], string.format("%.0f", newTat), "PX", string.format("%.0f", math.ceil((newTat - now) / 1000)))
+return {1, math.floor(diff / emission), 0, newTat - now}`)
+)
+
+var errUnexpectedReply = errors.New("cache: unexpected rate limit reply")
+
+// RateLimitResult result of a rate limit check
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration // how long to wait before next request is allowed, 0 if allowed
+	ResetAfter time.Duration // how long until the limiter is fully reset
+}
+
+// RateLimiter distributed rate limiter shared across all replicas
+type RateLimiter interface {
+	Allow(ctx context.Context, key string) (*RateLimitResult, error)
+}
+
+// NewSlidingWindowLimiter construct a sliding window log limiter which allows limit requests per window
+func NewSlidingWindowLimiter(client redis.Cmdable, prefix string, limit int, window time.Duration) RateLimiter {
+	return &slidingWindowLimiter{
+		client: client,
+		prefix: prefix,
+		limit:  limit,
+		window: window,
+	}
+}
+
+type slidingWindowLimiter struct {
+	client redis.Cmdable
+	prefix string
+	limit  int
+	window time.Duration
+}
+
+func (l *slidingWindowLimiter) Allow(ctx context.Context, key string) (*RateLimitResult, error) {
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	res, err := luaSlidingWindow.Run(ctx, l.client, []string{l.prefix + "." + key},
+		l.window.Microseconds(), l.limit, token).Result()
+	if err != nil {
+		return nil, err
+	}
+	return newRateLimitResult(res, l.limit)
+}
+
+// NewGCRALimiter construct a generic cell rate algorithm (token bucket) limiter,
+// which allows rate requests per period with bursts up to burst requests
+func NewGCRALimiter(client redis.Cmdable, prefix string, rate int, period time.Duration, burst int) RateLimiter {
+	if burst <= 0 {
+		burst = 1
+	}
+	if rate <= 0 {
+		rate = 1
+	}
+	emission := period / time.Duration(rate)
+
+	return &gcraLimiter{
+		client:      client,
+		prefix:      prefix,
+		burst:       burst,
+		emission:    emission,
+		burstOffset: emission * time.Duration(burst),
+	}
+}
+
+type gcraLimiter struct {
+	client      redis.Cmdable
+	prefix      string
+	burst       int
+	emission    time.Duration
+	burstOffset time.Duration
+}
+
+func (l *gcraLimiter) Allow(ctx context.Context, key string) (*RateLimitResult, error) {
+	res, err := luaGCRA.Run(ctx, l.client, []string{l.prefix + "." + key},
+		l.emission.Microseconds(), l.burstOffset.Microseconds()).Result()
+	if err != nil {
+		return nil, err
+	}
+	return newRateLimitResult(res, l.burst)
+}
+
+func newRateLimitResult(res interface{}, limit int) (*RateLimitResult, error) {
+	values, ok := res.([]interface{})
+	if !ok || len(values) != 4 {
+		return nil, errUnexpectedReply
+	}
+	nums := make([]int64, len(values))
+	for i, v := range values {
+		nums[i], _ = v.(int64)
+	}
+
+	return &RateLimitResult{
+		Allowed:    nums[0] == 1,
+		Limit:      limit,
+		Remaining:  int(nums[1]),
+		RetryAfter: time.Duration(nums[2]) * time.Microsecond,
+		ResetAfter: time.Duration(nums[3]) * time.Microsecond,
+	}, nil
+}
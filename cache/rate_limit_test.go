@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlidingWindowLimiter(t *testing.T) {
+	client := newTestRedis(t)
+	ctx := context.Background()
+	limiter := NewSlidingWindowLimiter(client, "test", 3, time.Second)
+
+	for i := 0; i < 3; i++ {
+		result, err := limiter.Allow(ctx, "key")
+		assert.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 3, result.Limit)
+		assert.Equal(t, 2-i, result.Remaining)
+		assert.Equal(t, time.Duration(0), result.RetryAfter)
+		assert.True(t, result.ResetAfter > 0 && result.ResetAfter <= time.Second)
+	}
+
+	result, err := limiter.Allow(ctx, "key")
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	assert.True(t, result.RetryAfter > 0 && result.RetryAfter <= time.Second)
+
+	// keys are limited independently
+	result, err = limiter.Allow(ctx, "other")
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+}
+
+func TestGCRALimiter(t *testing.T) {
+	client := newTestRedis(t)
+	ctx := context.Background()
+	limiter := NewGCRALimiter(client, "test", 10, time.Second, 2)
+
+	for i := 0; i < 2; i++ {
+		result, err := limiter.Allow(ctx, "key")
+		assert.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 2, result.Limit)
+		assert.Equal(t, 1-i, result.Remaining)
+	}
+
+	result, err := limiter.Allow(ctx, "key")
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.True(t, result.RetryAfter > 0 && result.RetryAfter <= 100*time.Millisecond)
+	assert.True(t, result.ResetAfter > 100*time.Millisecond && result.ResetAfter <= 200*time.Millisecond)
+
+	time.Sleep(result.RetryAfter)
+	result, err = limiter.Allow(ctx, "key")
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+}
@@ -1,11 +1,19 @@
 package cache
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 )
 
+// ErrInvalidCA is returned when tls ca file contains no certificate
+var ErrInvalidCA = errors.New("cache: invalid tls ca file")
+
 type RedisCfg struct {
 	Host       string `yaml:"host"`
 	Port       string `yaml:"port"`
@@ -13,23 +21,146 @@ type RedisCfg struct {
 	DB         int    `yaml:"db"`
 	LockPrefix string `yaml:"lock_prefix"`
 	LockTTLSec int    `yaml:"lock_ttl_sec"`
+
+	URL              string      `yaml:"url"`   // redis://, rediss:// or unix:// url, overrides host, port, password and db
+	Addrs            []string    `yaml:"addrs"` // cluster seed nodes or sentinel addresses, overrides host and port
+	Cluster          bool        `yaml:"cluster"`
+	MasterName       string      `yaml:"master_name"` // sentinel master name, enables failover client
+	Username         string      `yaml:"username"`
+	SentinelPassword string      `yaml:"sentinel_password"`
+	TLS              RedisTLSCfg `yaml:"tls"`
+
+	PoolSize       int `yaml:"pool_size"`
+	MinIdleConns   int `yaml:"min_idle_conns"`
+	DialTimeoutMs  int `yaml:"dial_timeout_ms"`
+	ReadTimeoutMs  int `yaml:"read_timeout_ms"`
+	WriteTimeoutMs int `yaml:"write_timeout_ms"`
+}
+
+// RedisTLSCfg redis tls config
+type RedisTLSCfg struct {
+	Enabled            bool   `yaml:"enabled"`
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
 }
 
+// NewRedis construct redis client, it is a sentinel failover client when master name is set,
+// a cluster client when cluster is enabled, otherwise a single node client
 func NewRedis(cfg *RedisCfg) (*RedisClient, error) {
-	opts := &redis.Options{}
-	opts.Network = "tcp"
-	opts.Addr = cfg.Host + ":" + cfg.Port
-	opts.Password = cfg.Password
-	opts.DB = cfg.DB
-	client := redis.NewClient(opts)
+	client, err := cfg.newClient()
+	if err != nil {
+		return nil, err
+	}
 	locker := NewLocker(client, cfg.LockPrefix, time.Duration(cfg.LockTTLSec)*time.Second)
+	simple, _ := client.(*redis.Client)
 	return &RedisClient{
-		Client: client,
-		Locker: locker,
+		UniversalClient: client,
+		Client:          simple,
+		Locker:          locker,
 	}, nil
 }
 
+func (cfg *RedisCfg) newClient() (redis.UniversalClient, error) {
+	opts, err := cfg.universalOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case opts.MasterName != "":
+		return redis.NewFailoverClient(opts.Failover()), nil
+	case cfg.Cluster:
+		return redis.NewClusterClient(opts.Cluster()), nil
+	}
+
+	simple := opts.Simple()
+	if cfg.URL != "" {
+		urlOpts, err := redis.ParseURL(cfg.URL)
+		if err != nil {
+			return nil, err
+		}
+		simple.Network = urlOpts.Network
+	}
+	return redis.NewClient(simple), nil
+}
+
+func (cfg *RedisCfg) universalOptions() (*redis.UniversalOptions, error) {
+	opts := &redis.UniversalOptions{
+		Addrs:            cfg.Addrs,
+		DB:               cfg.DB,
+		Username:         cfg.Username,
+		Password:         cfg.Password,
+		SentinelPassword: cfg.SentinelPassword,
+		MasterName:       cfg.MasterName,
+		PoolSize:         cfg.PoolSize,
+		MinIdleConns:     cfg.MinIdleConns,
+		DialTimeout:      time.Duration(cfg.DialTimeoutMs) * time.Millisecond,
+		ReadTimeout:      time.Duration(cfg.ReadTimeoutMs) * time.Millisecond,
+		WriteTimeout:     time.Duration(cfg.WriteTimeoutMs) * time.Millisecond,
+	}
+	if len(opts.Addrs) == 0 {
+		opts.Addrs = []string{net.JoinHostPort(cfg.Host, cfg.Port)}
+	}
+
+	if cfg.URL != "" {
+		urlOpts, err := redis.ParseURL(cfg.URL)
+		if err != nil {
+			return nil, err
+		}
+		opts.Addrs = []string{urlOpts.Addr}
+		opts.Username = urlOpts.Username
+		opts.Password = urlOpts.Password
+		opts.DB = urlOpts.DB
+		opts.TLSConfig = urlOpts.TLSConfig
+	}
+
+	if cfg.TLS.Enabled {
+		tlsConfig, err := cfg.TLS.config()
+		if err != nil {
+			return nil, err
+		}
+		opts.TLSConfig = tlsConfig
+	}
+
+	return opts, nil
+}
+
+func (cfg RedisTLSCfg) config() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CAFile != "" {
+		ca, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, ErrInvalidCA
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" && cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
 type RedisClient struct {
-	*redis.Client
+	redis.UniversalClient
+	// Client single node or sentinel failover client, nil for cluster clients.
+	// It keeps callers which need *redis.Client working.
+	Client *redis.Client
 	Locker Locker
 }
@@ -4,81 +4,143 @@ import (
 	"context"
 	"time"
 
-	"github.com/bsm/redislock"
-	"github.com/cenk/backoff"
 	"github.com/go-redis/redis/v8"
 )
 
+var (
+	// luaObtain set the lock and, if fencing is requested, increase the fencing counter in the same step,
+	// so a holder whose lease expired before obtaining can never get a newer token than the next holder
+	luaObtain = redis.NewScript(`
+if not redis.call("set", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return -1
+end
+if ARGV[3] == "1" then
+	return redis.call("incr", KEYS[2])
+end
+return 0`)
+	luaRelease = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`)
+	luaRefresh = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) else return 0 end`)
+	luaPTTL    = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pttl", KEYS[1]) else return -3 end`)
+)
+
 // Locker redis distribured lock
 type Locker interface {
-	Lock(ctx context.Context, key string, ttls ...time.Duration) (Releaser, error)
+	Lock(ctx context.Context, key string, opts ...LockOption) (Releaser, error)
+	// Do run fn while holding the lock, the lock is released when fn returns or panics,
+	// and fn's context is cancelled if the lease is lost
+	Do(ctx context.Context, key string, fn func(ctx context.Context) error, opts ...LockOption) error
 }
 
 // Releaser represent releasable lock
 type Releaser interface {
 	Release(ctx context.Context) error
+	// Refresh extend the lock lease with a new ttl, it returns ErrLockNotHeld if the lock is not held anymore
+	Refresh(ctx context.Context, ttl time.Duration) error
+	// TTL return the remaining lease, 0 if the lock has expired
+	TTL(ctx context.Context) (time.Duration, error)
+	// FencingToken return the token issued with the lock, false if the lock was not obtained WithFencing
+	FencingToken() (int64, bool)
 }
 
 // New construct a lock
 func NewLocker(client redis.Cmdable, prefix string, defaultTTL time.Duration) Locker {
-	expBackOff := newExpBackOff()
-	opts := &redislock.Options{
-		RetryStrategy: expBackOff,
-	}
 	if defaultTTL <= 0 {
 		defaultTTL = time.Second
 	}
 
 	return &locker{
 		prefix:     prefix,
-		lockClient: redislock.New(client),
-		opts:       opts,
-		backOff:    expBackOff,
+		client:     client,
 		defaultTTL: defaultTTL,
 	}
 }
 
 type locker struct {
 	prefix     string
-	lockClient *redislock.Client
-	opts       *redislock.Options
-	backOff    *expBackOff
+	client     redis.Cmdable
 	defaultTTL time.Duration
 }
 
-func (locker *locker) Lock(ctx context.Context, key string, ttls ...time.Duration) (Releaser, error) {
-	ttl := locker.defaultTTL
-	if len(ttls) > 0 {
-		ttl = ttls[0]
+// Do run fn while holding the lock of key
+func (locker *locker) Do(ctx context.Context, key string, fn func(ctx context.Context) error, opts ...LockOption) error {
+	return doWithLock(ctx, locker, key, fn, opts...)
+}
+
+// Lock obtain the lock, by default it retries with exponential backoff for at most one second.
+// The fencing counter is hash tagged by the lock key, so both live in the same cluster slot.
+func (locker *locker) Lock(ctx context.Context, key string, opts ...LockOption) (Releaser, error) {
+	o := newLockOptions(locker.defaultTTL, opts...)
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
 	}
 	lockKey := locker.prefix + "." + key
+	fencing := "0"
+	if o.fencing {
+		fencing = "1"
+	}
 
-	defer locker.backOff.Reset()
-	lock, err := locker.lockClient.Obtain(ctx, lockKey, ttl, locker.opts)
+	var fence int64
+	err = o.obtain(ctx, func(ctx context.Context) (bool, error) {
+		var err error
+		fence, err = luaObtain.Run(ctx, locker.client, []string{lockKey, "{" + lockKey + "}.fence"},
+			token, o.ttl.Milliseconds(), fencing).Int64()
+		if err != nil {
+			return false, err
+		}
+		return fence >= 0, nil
+	})
 	if err != nil {
-		return lock, err
+		return nil, err
 	}
 
+	lock := &tokenLock{client: locker.client, key: lockKey, token: token}
+	if o.fencing {
+		return &fencedLock{Releaser: lock, fence: fence}, nil
+	}
 	return lock, nil
 }
 
-func newExpBackOff() *expBackOff {
-	expBackOff := &expBackOff{backoff.NewExponentialBackOff()}
-	expBackOff.backOff.InitialInterval = 10 * time.Millisecond
-	expBackOff.backOff.MaxInterval = 300 * time.Millisecond
-	expBackOff.backOff.MaxElapsedTime = time.Second
-	expBackOff.backOff.Multiplier = 2
-	return expBackOff
+// tokenLock lock stored as a string key holding the owner token
+type tokenLock struct {
+	client redis.Cmdable
+	key    string
+	token  string
 }
 
-type expBackOff struct {
-	backOff *backoff.ExponentialBackOff
+func (lock *tokenLock) Release(ctx context.Context) error {
+	released, err := luaRelease.Run(ctx, lock.client, []string{lock.key}, lock.token).Bool()
+	if err != nil {
+		return err
+	}
+	if !released {
+		return ErrLockNotHeld
+	}
+	return nil
 }
 
-func (exp *expBackOff) Reset() {
-	exp.backOff.Reset()
+func (lock *tokenLock) Refresh(ctx context.Context, ttl time.Duration) error {
+	refreshed, err := luaRefresh.Run(ctx, lock.client, []string{lock.key}, lock.token, ttl.Milliseconds()).Bool()
+	if err != nil {
+		return err
+	}
+	if !refreshed {
+		return ErrLockNotHeld
+	}
+	return nil
 }
 
-func (exp *expBackOff) NextBackoff() time.Duration {
-	return exp.backOff.NextBackOff()
+func (lock *tokenLock) FencingToken() (int64, bool) {
+	return 0, false
+}
+
+func (lock *tokenLock) TTL(ctx context.Context) (time.Duration, error) {
+	ms, err := luaPTTL.Run(ctx, lock.client, []string{lock.key}, lock.token).Int64()
+	if err != nil {
+		return 0, err
+	}
+	if ms <= 0 {
+		return 0, nil
+	}
+	return time.Duration(ms) * time.Millisecond, nil
 }
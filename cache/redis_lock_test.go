@@ -0,0 +1,60 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocker(t *testing.T) {
+	client := newTestRedis(t)
+	ctx := context.Background()
+	locker := NewLocker(client, "test", time.Second)
+
+	lock, err := locker.Lock(ctx, "key")
+	assert.NoError(t, err)
+	_, err = locker.Lock(ctx, "key", TryLock())
+	assert.Equal(t, ErrNotObtained, err)
+
+	assert.NoError(t, lock.Refresh(ctx, 2*time.Second))
+	ttl, err := lock.TTL(ctx)
+	assert.NoError(t, err)
+	assert.True(t, ttl > time.Second && ttl <= 2*time.Second)
+
+	assert.NoError(t, lock.Release(ctx))
+	assert.Equal(t, ErrLockNotHeld, lock.Release(ctx))
+	assert.Equal(t, ErrLockNotHeld, lock.Refresh(ctx, time.Second))
+	ttl, err = lock.TTL(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), ttl)
+}
+
+func TestLockerFencing(t *testing.T) {
+	client := newTestRedis(t)
+	ctx := context.Background()
+	locker := NewLocker(client, "test", time.Second)
+
+	lock, err := locker.Lock(ctx, "key")
+	assert.NoError(t, err)
+	_, ok := lock.FencingToken()
+	assert.False(t, ok)
+	// the lock key is the same as before fencing was introduced, so old and new replicas exclude each other
+	assert.Equal(t, int64(1), client.Exists(ctx, "test.key").Val())
+	assert.NoError(t, lock.Release(ctx))
+
+	lock, err = locker.Lock(ctx, "key", WithFencing())
+	assert.NoError(t, err)
+	fence, ok := lock.FencingToken()
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), fence)
+	assert.Equal(t, "1", client.Get(ctx, "{test.key}.fence").Val())
+	assert.NoError(t, lock.Release(ctx))
+
+	lock, err = locker.Lock(ctx, "key", WithFencing())
+	assert.NoError(t, err)
+	fence, _ = lock.FencingToken()
+	assert.Equal(t, int64(2), fence)
+	assert.NoError(t, lock.Release(ctx))
+}
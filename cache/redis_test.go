@@ -0,0 +1,41 @@
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ory/dockertest"
+	"github.com/ory/dockertest/docker"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestRedis run a redis container for the test, the test is skipped when docker is not available
+func newTestRedis(t *testing.T) *RedisClient {
+	t.Helper()
+	pool, err := dockertest.NewPool("")
+	if err == nil {
+		err = pool.Client.Ping()
+	}
+	if err != nil {
+		t.Skipf("docker is not available: %v", err)
+	}
+
+	resource, err := pool.RunWithOptions(&dockertest.RunOptions{Repository: "redis", Tag: "6.2-alpine"},
+		func(config *docker.HostConfig) {
+			config.AutoRemove = true
+		})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { _ = pool.Purge(resource) })
+
+	client, err := NewRedis(&RedisCfg{Host: "localhost", Port: resource.GetPort("6379/tcp")})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	if !assert.NoError(t, pool.Retry(func() error { return client.Ping(context.Background()).Err() })) {
+		t.FailNow()
+	}
+	return client
+}
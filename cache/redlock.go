@@ -0,0 +1,246 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrNoRedisNode is returned when red locker is constructed without any redis node
+var ErrNoRedisNode = errors.New("cache: no redis node")
+
+// RedLocker locker over independent redis nodes, Close closes the node clients
+type RedLocker interface {
+	Locker
+	Close() error
+}
+
+// NewRedLocker construct a redlock-style locker, a lock is obtained only when
+// a majority of independent redis nodes grant it within the validity window.
+func NewRedLocker(cfgs []*RedisCfg, prefix string, defaultTTL time.Duration) (RedLocker, error) {
+	if len(cfgs) == 0 {
+		return nil, ErrNoRedisNode
+	}
+
+	nodes := make([]redis.UniversalClient, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		node, err := cfg.newClient()
+		if err != nil {
+			for _, node := range nodes {
+				node.Close()
+			}
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	return newRedLocker(nodes, prefix, defaultTTL), nil
+}
+
+func newRedLocker(nodes []redis.UniversalClient, prefix string, defaultTTL time.Duration) *redLocker {
+	if defaultTTL <= 0 {
+		defaultTTL = time.Second
+	}
+	return &redLocker{
+		prefix:     prefix,
+		nodes:      nodes,
+		quorum:     len(nodes)/2 + 1,
+		defaultTTL: defaultTTL,
+	}
+}
+
+type redLocker struct {
+	prefix     string
+	nodes      []redis.UniversalClient
+	quorum     int
+	defaultTTL time.Duration
+}
+
+// Close close every node client
+func (locker *redLocker) Close() error {
+	var err error
+	for _, node := range locker.nodes {
+		if closeErr := node.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Do run fn while holding the lock of key
+func (locker *redLocker) Do(ctx context.Context, key string, fn func(ctx context.Context) error, opts ...LockOption) error {
+	return doWithLock(ctx, locker, key, fn, opts...)
+}
+
+// Lock obtain the lock on a majority of nodes, acquisition options are the same as the single node locker
+func (locker *redLocker) Lock(ctx context.Context, key string, opts ...LockOption) (Releaser, error) {
+	o := newLockOptions(locker.defaultTTL, opts...)
+	if o.fencing {
+		return nil, ErrFencingUnsupported
+	}
+	lock := &redLock{
+		locker: locker,
+		key:    locker.prefix + "." + key,
+	}
+
+	err := o.obtain(ctx, func(ctx context.Context) (bool, error) {
+		token, err := randomToken()
+		if err != nil {
+			return false, err
+		}
+		lock.token = token
+		return locker.acquire(ctx, lock, o.ttl)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return lock, nil
+}
+
+func (locker *redLocker) acquire(ctx context.Context, lock *redLock, ttl time.Duration) (bool, error) {
+	start := time.Now()
+	granted, err := locker.eachNode(ctx, ttl, func(ctx context.Context, node redis.Cmdable) (bool, error) {
+		return node.SetNX(ctx, lock.key, lock.token, ttl).Result()
+	})
+
+	if granted >= locker.quorum && locker.validity(ttl, time.Since(start)) > 0 {
+		return true, nil
+	}
+
+	// release partial locks, otherwise they block other holders until expired
+	locker.eachNode(context.Background(), ttl, func(ctx context.Context, node redis.Cmdable) (bool, error) {
+		return luaRelease.Run(ctx, node, []string{lock.key}, lock.token).Bool()
+	})
+	if err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// validity remaining lock validity after correcting elapsed time and clock drift
+func (locker *redLocker) validity(ttl, elapsed time.Duration) time.Duration {
+	drift := ttl/100 + 2*time.Millisecond
+	return ttl - elapsed - drift
+}
+
+// eachNode run fn on every node concurrently, each node gets a small timeout so that
+// a dead node does not eat the validity window. It returns the number of successful nodes,
+// and the last node error only when so many nodes failed that a majority can not be reached.
+func (locker *redLocker) eachNode(ctx context.Context, ttl time.Duration, fn func(ctx context.Context, node redis.Cmdable) (bool, error)) (int, error) {
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		success int
+		failed  int
+		lastErr error
+	)
+
+	nodeTimeout := ttl / 10
+	for _, node := range locker.nodes {
+		wg.Add(1)
+		go func(node redis.UniversalClient) {
+			defer wg.Done()
+			nodeCtx, cancel := context.WithTimeout(ctx, nodeTimeout)
+			defer cancel()
+
+			ok, err := fn(nodeCtx, node)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && err != redis.Nil {
+				failed++
+				lastErr = err
+			}
+			if ok {
+				success++
+			}
+		}(node)
+	}
+	wg.Wait()
+
+	if failed <= len(locker.nodes)-locker.quorum {
+		return success, nil
+	}
+	return success, lastErr
+}
+
+// redLock lock held on a majority of nodes
+type redLock struct {
+	locker *redLocker
+	key    string
+	token  string
+}
+
+// Release release the lock on every node
+func (lock *redLock) Release(ctx context.Context) error {
+	released, err := lock.locker.eachNode(ctx, lock.locker.defaultTTL, func(ctx context.Context, node redis.Cmdable) (bool, error) {
+		return luaRelease.Run(ctx, node, []string{lock.key}, lock.token).Bool()
+	})
+	if released == 0 {
+		if err != nil {
+			return err
+		}
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// Refresh extend the lock on every node, it fails if a majority of nodes can not be extended in time
+func (lock *redLock) Refresh(ctx context.Context, ttl time.Duration) error {
+	start := time.Now()
+	refreshed, err := lock.locker.eachNode(ctx, ttl, func(ctx context.Context, node redis.Cmdable) (bool, error) {
+		return luaRefresh.Run(ctx, node, []string{lock.key}, lock.token, ttl.Milliseconds()).Bool()
+	})
+	if refreshed >= lock.locker.quorum && lock.locker.validity(ttl, time.Since(start)) > 0 {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return ErrLockNotHeld
+}
+
+func (lock *redLock) FencingToken() (int64, bool) {
+	return 0, false
+}
+
+// TTL return the lease still granted by a majority of nodes
+func (lock *redLock) TTL(ctx context.Context) (time.Duration, error) {
+	var (
+		mu   sync.Mutex
+		ttls = make([]time.Duration, 0, len(lock.locker.nodes))
+	)
+	_, err := lock.locker.eachNode(ctx, lock.locker.defaultTTL, func(ctx context.Context, node redis.Cmdable) (bool, error) {
+		ms, err := luaPTTL.Run(ctx, node, []string{lock.key}, lock.token).Int64()
+		if err != nil || ms <= 0 {
+			return false, err
+		}
+		mu.Lock()
+		ttls = append(ttls, time.Duration(ms)*time.Millisecond)
+		mu.Unlock()
+		return true, nil
+	})
+	if len(ttls) < lock.locker.quorum {
+		return 0, err
+	}
+
+	sort.Slice(ttls, func(i, j int) bool { return ttls[i] > ttls[j] })
+	ttl := lock.locker.validity(ttls[lock.locker.quorum-1], 0)
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
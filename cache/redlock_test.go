@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedLockerValidity(t *testing.T) {
+	locker := newRedLocker(make([]redis.UniversalClient, 3), "test", time.Second)
+	assert.Equal(t, 2, locker.quorum)
+	assert.Equal(t, 888*time.Millisecond, locker.validity(time.Second, 100*time.Millisecond))
+	assert.True(t, locker.validity(time.Second, time.Second) < 0)
+}
+
+func TestRedLockerNodeDown(t *testing.T) {
+	ctx := context.Background()
+	first, second := newTestRedis(t), newTestRedis(t)
+	down := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+
+	// a majority of nodes is enough to lock, refresh and release
+	locker := newRedLocker([]redis.UniversalClient{first, second, down}, "test", time.Second)
+	defer locker.Close()
+	lock, err := locker.Lock(ctx, "key", TryLock())
+	assert.NoError(t, err)
+	_, err = locker.Lock(ctx, "key", TryLock())
+	assert.Equal(t, ErrNotObtained, err)
+
+	assert.NoError(t, lock.Refresh(ctx, 2*time.Second))
+	ttl, err := lock.TTL(ctx)
+	assert.NoError(t, err)
+	assert.True(t, ttl > time.Second && ttl < 2*time.Second)
+	assert.NoError(t, lock.Release(ctx))
+	assert.Equal(t, ErrLockNotHeld, lock.Refresh(ctx, time.Second))
+
+	// a lock granted by a minority is rolled back
+	assert.NoError(t, first.Set(ctx, "test.key", "other", time.Minute).Err())
+	_, err = locker.Lock(ctx, "key", TryLock())
+	assert.Equal(t, ErrNotObtained, err)
+	assert.Equal(t, int64(0), second.Exists(ctx, "test.key").Val())
+
+	assert.NoError(t, first.Del(ctx, "test.key").Err())
+	lock, err = locker.Lock(ctx, "key", TryLock())
+	assert.NoError(t, err)
+	assert.NoError(t, lock.Release(ctx))
+}
+
+func TestRedLockerMajorityDown(t *testing.T) {
+	ctx := context.Background()
+	down := func() redis.UniversalClient {
+		return redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	}
+	locker := newRedLocker([]redis.UniversalClient{newTestRedis(t), down(), down()}, "test", time.Second)
+	defer locker.Close()
+
+	// node errors are reported when they make a majority unreachable
+	_, err := locker.Lock(ctx, "key", TryLock())
+	assert.Error(t, err)
+	assert.NotEqual(t, ErrNotObtained, err)
+}
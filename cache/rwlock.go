@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	// KEYS[1] writer key, KEYS[2] readers sorted set, KEYS[3] pending writer key
+	luaReadLock = redis.NewScript(`
+redis.replicate_commands()
+if redis.call("exists", KEYS[1]) == 1 or redis.call("exists", KEYS[3]) == 1 then
+	return 0
+end
+local t = redis.call("time")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+redis.call("zremrangebyscore", KEYS[2], "-inf", now)
+redis.call("zadd", KEYS[2], now + tonumber(ARGV[2]), ARGV[1])
+if redis.call("pttl", KEYS[2]) < tonumber(ARGV[2]) then
+	redis.call("pexpire", KEYS[2], ARGV[2])
+end
+return 1`)
+	luaWriteLock = redis.NewScript(`
+redis.replicate_commands()
+local t = redis.call("time")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+redis.call("zremrangebyscore", KEYS[2], "-inf", now)
+if redis.call("zcard", KEYS[2]) > 0 then
+	redis.call("set", KEYS[3], ARGV[1], "PX", ARGV[2])
+	return 0
+end
+if redis.call("set", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	redis.call("del", KEYS[3])
+	return 1
+end
+return 0`)
+)
+
+// RWLock distributed read/write lock, many readers share the lock while a writer holds it exclusively.
+// Writers are preferred, once a writer is waiting new readers are refused until it obtains the lock,
+// a writer which gives up keeps new readers out for at most its lock ttl.
+type RWLock interface {
+	RLock(ctx context.Context, opts ...LockOption) (Releaser, error)
+	Lock(ctx context.Context, opts ...LockOption) (Releaser, error)
+}
+
+// NewRWLock construct a read/write lock on key, keys are prefixed like Locker keys
+func NewRWLock(client redis.Cmdable, prefix, key string, defaultTTL time.Duration) RWLock {
+	if defaultTTL <= 0 {
+		defaultTTL = time.Second
+	}
+
+	lockKey := "{" + prefix + "." + key + "}"
+	return &rwLock{
+		client:     client,
+		writerKey:  lockKey + ".writer",
+		readersKey: lockKey + ".readers",
+		pendingKey: lockKey + ".pending",
+		defaultTTL: defaultTTL,
+	}
+}
+
+type rwLock struct {
+	client     redis.Cmdable
+	writerKey  string
+	readersKey string
+	pendingKey string
+	defaultTTL time.Duration
+}
+
+func (rw *rwLock) keys() []string {
+	return []string{rw.writerKey, rw.readersKey, rw.pendingKey}
+}
+
+// RLock obtain a shared read lock, acquisition options are the same as Locker.Lock
+func (rw *rwLock) RLock(ctx context.Context, opts ...LockOption) (Releaser, error) {
+	o := newLockOptions(rw.defaultTTL, opts...)
+	if o.fencing {
+		return nil, ErrFencingUnsupported
+	}
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	err = o.obtain(ctx, func(ctx context.Context) (bool, error) {
+		return luaReadLock.Run(ctx, rw.client, rw.keys(), token, o.ttl.Milliseconds()).Bool()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &permitLock{client: rw.client, key: rw.readersKey, token: token}, nil
+}
+
+// Lock obtain the exclusive write lock, acquisition options are the same as Locker.Lock
+func (rw *rwLock) Lock(ctx context.Context, opts ...LockOption) (Releaser, error) {
+	o := newLockOptions(rw.defaultTTL, opts...)
+	if o.fencing {
+		return nil, ErrFencingUnsupported
+	}
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	err = o.obtain(ctx, func(ctx context.Context) (bool, error) {
+		return luaWriteLock.Run(ctx, rw.client, rw.keys(), token, o.ttl.Milliseconds()).Bool()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokenLock{client: rw.client, key: rw.writerKey, token: token}, nil
+}
@@ -0,0 +1,50 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRWLock(t *testing.T) {
+	client := newTestRedis(t)
+	ctx := context.Background()
+	rw := NewRWLock(client, "test", "rw", time.Second)
+
+	// readers share the lock, a writer waits for them
+	first, err := rw.RLock(ctx)
+	assert.NoError(t, err)
+	second, err := rw.RLock(ctx, TryLock())
+	assert.NoError(t, err)
+	_, err = rw.Lock(ctx, TryLock())
+	assert.Equal(t, ErrNotObtained, err)
+	assert.Equal(t, int64(2), client.ZCard(ctx, "{test.rw}.readers").Val())
+
+	// a waiting writer keeps new readers out, so it is not starved
+	writerDone := make(chan Releaser)
+	go func() {
+		writer, err := rw.Lock(ctx, WithLinearBackoff(10*time.Millisecond), WithMaxWait(time.Second))
+		assert.NoError(t, err)
+		writerDone <- writer
+	}()
+	assert.Eventually(t, func() bool {
+		return client.Exists(ctx, "{test.rw}.pending").Val() == 1
+	}, time.Second, 10*time.Millisecond)
+	_, err = rw.RLock(ctx, TryLock())
+	assert.Equal(t, ErrNotObtained, err)
+
+	assert.NoError(t, first.Release(ctx))
+	assert.NoError(t, second.Release(ctx))
+	writer := <-writerDone
+	assert.NotNil(t, writer)
+	assert.Equal(t, int64(0), client.Exists(ctx, "{test.rw}.pending").Val())
+	_, err = rw.RLock(ctx, TryLock())
+	assert.Equal(t, ErrNotObtained, err)
+
+	assert.NoError(t, writer.Release(ctx))
+	reader, err := rw.RLock(ctx, TryLock())
+	assert.NoError(t, err)
+	assert.NoError(t, reader.Release(ctx))
+}
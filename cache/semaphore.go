@@ -0,0 +1,143 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrInvalidPermits is returned when a semaphore is constructed with less than one permit
+var ErrInvalidPermits = errors.New("cache: semaphore permits must be positive")
+
+// permit holders are kept in a sorted set scored by their expiry in redis server time,
+// so a crashed holder can not leak its permit.
+var (
+	luaPermitAcquire = redis.NewScript(`
+redis.replicate_commands()
+local t = redis.call("time")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+redis.call("zremrangebyscore", KEYS[1], "-inf", now)
+if redis.call("zcard", KEYS[1]) >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call("zadd", KEYS[1], now + tonumber(ARGV[3]), ARGV[1])
+if redis.call("pttl", KEYS[1]) < tonumber(ARGV[3]) then
+	redis.call("pexpire", KEYS[1], ARGV[3])
+end
+return 1`)
+	luaPermitRefresh = redis.NewScript(`
+redis.replicate_commands()
+local t = redis.call("time")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local expireAt = redis.call("zscore", KEYS[1], ARGV[1])
+if not expireAt or tonumber(expireAt) <= now then
+	return 0
+end
+redis.call("zadd", KEYS[1], "XX", now + tonumber(ARGV[2]), ARGV[1])
+if redis.call("pttl", KEYS[1]) < tonumber(ARGV[2]) then
+	redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 1`)
+	luaPermitTTL = redis.NewScript(`
+local t = redis.call("time")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local expireAt = redis.call("zscore", KEYS[1], ARGV[1])
+if not expireAt or tonumber(expireAt) <= now then
+	return 0
+end
+return tonumber(expireAt) - now`)
+)
+
+// Semaphore distributed counting semaphore
+type Semaphore interface {
+	// Acquire obtain one permit, acquisition options are the same as Locker.Lock
+	Acquire(ctx context.Context, opts ...LockOption) (Releaser, error)
+}
+
+// NewSemaphore construct a semaphore which allows at most permits holders of the key at the same time,
+// keys are prefixed like Locker keys
+func NewSemaphore(client redis.Cmdable, prefix, key string, permits int, defaultTTL time.Duration) (Semaphore, error) {
+	if permits < 1 {
+		return nil, ErrInvalidPermits
+	}
+	if defaultTTL <= 0 {
+		defaultTTL = time.Second
+	}
+
+	return &semaphore{
+		client:     client,
+		key:        prefix + "." + key,
+		permits:    permits,
+		defaultTTL: defaultTTL,
+	}, nil
+}
+
+type semaphore struct {
+	client     redis.Cmdable
+	key        string
+	permits    int
+	defaultTTL time.Duration
+}
+
+func (sem *semaphore) Acquire(ctx context.Context, opts ...LockOption) (Releaser, error) {
+	o := newLockOptions(sem.defaultTTL, opts...)
+	if o.fencing {
+		return nil, ErrFencingUnsupported
+	}
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	err = o.obtain(ctx, func(ctx context.Context) (bool, error) {
+		return luaPermitAcquire.Run(ctx, sem.client, []string{sem.key}, token, sem.permits, o.ttl.Milliseconds()).Bool()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &permitLock{client: sem.client, key: sem.key, token: token}, nil
+}
+
+// permitLock permit held in a sorted set
+type permitLock struct {
+	client redis.Cmdable
+	key    string
+	token  string
+}
+
+func (lock *permitLock) Release(ctx context.Context) error {
+	removed, err := lock.client.ZRem(ctx, lock.key, lock.token).Result()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+func (lock *permitLock) Refresh(ctx context.Context, ttl time.Duration) error {
+	refreshed, err := luaPermitRefresh.Run(ctx, lock.client, []string{lock.key}, lock.token, ttl.Milliseconds()).Bool()
+	if err != nil {
+		return err
+	}
+	if !refreshed {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+func (lock *permitLock) FencingToken() (int64, bool) {
+	return 0, false
+}
+
+func (lock *permitLock) TTL(ctx context.Context) (time.Duration, error) {
+	ms, err := luaPermitTTL.Run(ctx, lock.client, []string{lock.key}, lock.token).Int64()
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
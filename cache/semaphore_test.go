@@ -0,0 +1,40 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSemaphore(t *testing.T) {
+	_, err := NewSemaphore(nil, "test", "sem", 0, time.Second)
+	assert.Equal(t, ErrInvalidPermits, err)
+
+	client := newTestRedis(t)
+	ctx := context.Background()
+	sem, err := NewSemaphore(client, "test", "sem", 2, time.Second)
+	assert.NoError(t, err)
+
+	first, err := sem.Acquire(ctx)
+	assert.NoError(t, err)
+	second, err := sem.Acquire(ctx, TryLock())
+	assert.NoError(t, err)
+	_, err = sem.Acquire(ctx, TryLock())
+	assert.Equal(t, ErrNotObtained, err)
+	assert.Equal(t, int64(2), client.ZCard(ctx, "test.sem").Val())
+
+	assert.NoError(t, second.Refresh(ctx, 2*time.Second))
+	ttl, err := second.TTL(ctx)
+	assert.NoError(t, err)
+	assert.True(t, ttl > time.Second && ttl <= 2*time.Second)
+
+	assert.NoError(t, first.Release(ctx))
+	assert.Equal(t, ErrLockNotHeld, first.Release(ctx))
+	assert.Equal(t, ErrLockNotHeld, first.Refresh(ctx, time.Second))
+	third, err := sem.Acquire(ctx, TryLock())
+	assert.NoError(t, err)
+	assert.NoError(t, third.Release(ctx))
+	assert.NoError(t, second.Release(ctx))
+}
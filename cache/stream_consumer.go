@@ -0,0 +1,375 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/rs/xid"
+	"github.com/vx416/gox/ctxutil"
+	"github.com/vx416/gox/log"
+)
+
+// StreamRequestIDField message field which carries the request id of the producer
+const StreamRequestIDField = "request_id"
+
+// StreamHandler handle one stream message, the message is acknowledged when it returns nil
+type StreamHandler func(ctx context.Context, msg redis.XMessage) error
+
+// StreamConsumer redis streams consumer group worker
+type StreamConsumer interface {
+	// Run consume messages until ctx is done, in-flight messages are given the shutdown timeout to finish
+	// before their context is cancelled, and Run returns once every handler returned
+	Run(ctx context.Context) error
+}
+
+// StreamConsumerCfg stream consumer config
+type StreamConsumerCfg struct {
+	Stream            string `yaml:"stream"`
+	Group             string `yaml:"group"`
+	Consumer          string `yaml:"consumer"` // default is hostname with a random suffix
+	Workers           int    `yaml:"workers"`
+	BatchSize         int    `yaml:"batch_size"`
+	BlockMs           int    `yaml:"block_ms"`
+	MaxRetries        int    `yaml:"max_retries"`         // in-process retries before the message is left pending, default is 3, negative disables retries
+	MaxDeliveries     int    `yaml:"max_deliveries"`      // messages delivered more times are moved to dead letter stream
+	DeadLetterStream  string `yaml:"dead_letter_stream"`  // default is stream with .dead suffix
+	ClaimIdleSec      int    `yaml:"claim_idle_sec"`      // pending messages idle longer are claimed from their consumer, it requires redis 6.2 or later
+	ShutdownTimeoutMs int    `yaml:"shutdown_timeout_ms"` // grace period of in-flight messages after ctx is done, default is 30 seconds
+}
+
+// NewStreamConsumer construct a consumer group worker pool
+func NewStreamConsumer(client redis.UniversalClient, cfg *StreamConsumerCfg, handler StreamHandler) StreamConsumer {
+	c := &streamConsumer{
+		client:          client,
+		handler:         handler,
+		stream:          cfg.Stream,
+		group:           cfg.Group,
+		consumer:        cfg.Consumer,
+		workers:         cfg.Workers,
+		batchSize:       int64(cfg.BatchSize),
+		block:           time.Duration(cfg.BlockMs) * time.Millisecond,
+		maxRetries:      cfg.MaxRetries,
+		maxDeliveries:   int64(cfg.MaxDeliveries),
+		deadLetter:      cfg.DeadLetterStream,
+		claimIdle:       time.Duration(cfg.ClaimIdleSec) * time.Second,
+		shutdownTimeout: time.Duration(cfg.ShutdownTimeoutMs) * time.Millisecond,
+	}
+
+	if c.consumer == "" {
+		hostname, _ := os.Hostname()
+		c.consumer = hostname + "-" + xid.New().String()
+	}
+	if c.workers <= 0 {
+		c.workers = runtime.NumCPU()
+	}
+	if c.batchSize <= 0 {
+		c.batchSize = int64(c.workers)
+	}
+	if c.block <= 0 {
+		c.block = 5 * time.Second
+	}
+	if c.maxRetries == 0 {
+		c.maxRetries = 3
+	}
+	if c.maxDeliveries <= 0 {
+		c.maxDeliveries = 5
+	}
+	if c.deadLetter == "" {
+		c.deadLetter = c.stream + ".dead"
+	}
+	if c.claimIdle <= 0 {
+		c.claimIdle = time.Minute
+	}
+	if c.shutdownTimeout <= 0 {
+		c.shutdownTimeout = 30 * time.Second
+	}
+	return c
+}
+
+type streamConsumer struct {
+	client          redis.UniversalClient
+	handler         StreamHandler
+	stream          string
+	group           string
+	consumer        string
+	workers         int
+	batchSize       int64
+	block           time.Duration
+	maxRetries      int
+	maxDeliveries   int64
+	deadLetter      string
+	claimIdle       time.Duration
+	shutdownTimeout time.Duration
+}
+
+func (c *streamConsumer) Run(ctx context.Context) error {
+	err := c.client.XGroupCreateMkStream(ctx, c.stream, c.group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+
+	// handlers run with a context detached from ctx cancellation, so in-flight messages can finish on shutdown,
+	// it is cancelled once the shutdown timeout elapsed
+	baseCtx, cancel := context.WithCancel(log.Ctx(ctx).Field("stream", c.stream).Field("consumer", c.consumer).Attach(context.Background()))
+	defer cancel()
+	jobs := make(chan redis.XMessage)
+	var wg sync.WaitGroup
+	for i := 0; i < c.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for msg := range jobs {
+				c.process(baseCtx, msg)
+			}
+		}()
+	}
+
+	claimDone := make(chan struct{})
+	go func() {
+		defer close(claimDone)
+		c.claimLoop(ctx, jobs)
+	}()
+
+	err = c.readLoop(ctx, jobs)
+	<-claimDone
+	close(jobs)
+
+	timer := time.AfterFunc(c.shutdownTimeout, cancel)
+	defer timer.Stop()
+	wg.Wait()
+	return err
+}
+
+func (c *streamConsumer) readLoop(ctx context.Context, jobs chan<- redis.XMessage) error {
+	for ctx.Err() == nil {
+		streams, err := c.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    c.group,
+			Consumer: c.consumer,
+			Streams:  []string{c.stream, ">"},
+			Count:    c.batchSize,
+			Block:    c.block,
+		}).Result()
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			log.Ctx(ctx).Err(err).Warn("stream consumer: read group failed")
+			sleepCtx(ctx, time.Second)
+			continue
+		}
+
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				if !dispatch(ctx, jobs, msg) {
+					return nil
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// claimLoop take over pending messages of dead or stuck consumers, and move poison messages to dead letter stream
+func (c *streamConsumer) claimLoop(ctx context.Context, jobs chan<- redis.XMessage) {
+	ticker := time.NewTicker(c.claimIdle / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		start := "0-0"
+		for ctx.Err() == nil {
+			next, msgs, err := c.autoClaim(ctx, start)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Ctx(ctx).Err(err).Warn("stream consumer: auto claim failed")
+				}
+				break
+			}
+
+			for _, msg := range msgs {
+				if c.exceedDeliveries(ctx, msg) {
+					c.moveToDeadLetter(ctx, msg)
+					continue
+				}
+				if !dispatch(ctx, jobs, msg) {
+					return
+				}
+			}
+			if next == "0-0" || next == "" {
+				break
+			}
+			start = next
+		}
+	}
+}
+
+// autoClaim issue XAUTOCLAIM and return the next start id and claimed messages, it requires redis 6.2 or later
+func (c *streamConsumer) autoClaim(ctx context.Context, start string) (string, []redis.XMessage, error) {
+	res, err := c.client.Do(ctx, "xautoclaim", c.stream, c.group, c.consumer,
+		c.claimIdle.Milliseconds(), start, "count", c.batchSize).Result()
+	if err != nil {
+		return "", nil, err
+	}
+
+	next, msgs, deleted, err := parseAutoClaim(res)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(deleted) > 0 {
+		// entries were deleted from the stream while pending
+		c.client.XAck(ctx, c.stream, c.group, deleted...)
+	}
+	return next, msgs, nil
+}
+
+// parseAutoClaim parse XAUTOCLAIM reply into the next start id, claimed messages and ids of deleted entries
+func parseAutoClaim(res interface{}) (string, []redis.XMessage, []string, error) {
+	reply, ok := res.([]interface{})
+	if !ok || len(reply) < 2 {
+		return "", nil, nil, fmt.Errorf("cache: unexpected xautoclaim reply %v", res)
+	}
+	next, _ := reply[0].(string)
+	entries, _ := reply[1].([]interface{})
+
+	var deleted []string
+	msgs := make([]redis.XMessage, 0, len(entries))
+	for _, entry := range entries {
+		fields, ok := entry.([]interface{})
+		if !ok || len(fields) != 2 {
+			continue
+		}
+		id, _ := fields[0].(string)
+		kvs, ok := fields[1].([]interface{})
+		if !ok {
+			deleted = append(deleted, id)
+			continue
+		}
+
+		values := make(map[string]interface{}, len(kvs)/2)
+		for i := 0; i+1 < len(kvs); i += 2 {
+			key, _ := kvs[i].(string)
+			values[key] = kvs[i+1]
+		}
+		msgs = append(msgs, redis.XMessage{ID: id, Values: values})
+	}
+	return next, msgs, deleted, nil
+}
+
+func (c *streamConsumer) exceedDeliveries(ctx context.Context, msg redis.XMessage) bool {
+	pending, err := c.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: c.stream,
+		Group:  c.group,
+		Start:  msg.ID,
+		End:    msg.ID,
+		Count:  1,
+	}).Result()
+	if err != nil || len(pending) == 0 {
+		return false
+	}
+	return pending[0].RetryCount > c.maxDeliveries
+}
+
+func (c *streamConsumer) moveToDeadLetter(ctx context.Context, msg redis.XMessage) {
+	values := make(map[string]interface{}, len(msg.Values)+1)
+	for k, v := range msg.Values {
+		values[k] = v
+	}
+	values["origin_id"] = msg.ID
+
+	logger := log.Ctx(ctx).Field("message_id", msg.ID)
+	if err := c.client.XAdd(ctx, &redis.XAddArgs{Stream: c.deadLetter, Values: values}).Err(); err != nil {
+		logger.Err(err).Error("stream consumer: move to dead letter failed")
+		return
+	}
+	if err := c.client.XAck(ctx, c.stream, c.group, msg.ID).Err(); err != nil {
+		logger.Err(err).Error("stream consumer: ack dead letter failed")
+		return
+	}
+	logger.Warn("stream consumer: message moved to dead letter")
+}
+
+// process run handler with retry, the message is left pending when every attempt failed
+func (c *streamConsumer) process(ctx context.Context, msg redis.XMessage) {
+	reqID, _ := msg.Values[StreamRequestIDField].(string)
+	if reqID != "" {
+		ctx = ctxutil.WithReqID(ctx, reqID)
+	}
+	ctx, reqID = ctxutil.GetReqID(ctx)
+	logger := log.Ctx(ctx).Field("request_id", reqID).Field("message_id", msg.ID)
+	ctx = logger.Attach(ctx)
+
+	if err := c.handleWithRetry(ctx, msg); err != nil {
+		logger.Err(err).Error("stream consumer: handle message failed")
+		return
+	}
+	if err := c.client.XAck(ctx, c.stream, c.group, msg.ID).Err(); err != nil {
+		logger.Err(err).Error("stream consumer: ack failed")
+	}
+}
+
+// handleWithRetry run handler up to maxRetries+1 times, it returns the error of the last attempt.
+// Retrying stops when ctx is done.
+func (c *streamConsumer) handleWithRetry(ctx context.Context, msg redis.XMessage) error {
+	retry := &expBackoff{min: 100 * time.Millisecond, max: 5 * time.Second}
+	for attempt := 0; ; attempt++ {
+		err := c.handle(ctx, msg)
+		if err == nil || attempt >= c.maxRetries || ctx.Err() != nil {
+			return err
+		}
+		log.Ctx(ctx).Err(err).Warn("stream consumer: handle message failed, retrying")
+		sleepCtx(ctx, retry.NextBackoff())
+	}
+}
+
+func (c *streamConsumer) handle(ctx context.Context, msg redis.XMessage) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %+v", r)
+		}
+	}()
+	return c.handler(ctx, msg)
+}
+
+// AddStreamMessage append message to stream, the request id of ctx is propagated to consumers
+func AddStreamMessage(ctx context.Context, client redis.Cmdable, stream string, values map[string]interface{}) (string, error) {
+	_, reqID := ctxutil.GetReqID(ctx)
+	msgValues := make(map[string]interface{}, len(values)+1)
+	for k, v := range values {
+		msgValues[k] = v
+	}
+	msgValues[StreamRequestIDField] = reqID
+
+	return client.XAdd(ctx, &redis.XAddArgs{Stream: stream, Values: msgValues}).Result()
+}
+
+func dispatch(ctx context.Context, jobs chan<- redis.XMessage, msg redis.XMessage) bool {
+	select {
+	case jobs <- msg:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
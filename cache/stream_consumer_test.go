@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAutoClaim(t *testing.T) {
+	res := []interface{}{
+		"1-5",
+		[]interface{}{
+			[]interface{}{"1-1", []interface{}{"name", "a", "request_id", "req"}},
+			[]interface{}{"1-2", nil},
+			[]interface{}{"1-3", []interface{}{"name", "b"}},
+		},
+	}
+	next, msgs, deleted, err := parseAutoClaim(res)
+	assert.NoError(t, err)
+	assert.Equal(t, "1-5", next)
+	assert.Equal(t, []redis.XMessage{
+		{ID: "1-1", Values: map[string]interface{}{"name": "a", "request_id": "req"}},
+		{ID: "1-3", Values: map[string]interface{}{"name": "b"}},
+	}, msgs)
+	assert.Equal(t, []string{"1-2"}, deleted)
+
+	// redis 7 appends deleted ids as the third element
+	next, msgs, _, err = parseAutoClaim([]interface{}{"0-0", []interface{}{}, []interface{}{}})
+	assert.NoError(t, err)
+	assert.Equal(t, "0-0", next)
+	assert.Empty(t, msgs)
+
+	_, _, _, err = parseAutoClaim([]interface{}{"0-0"})
+	assert.Error(t, err)
+	_, _, _, err = parseAutoClaim("ERR")
+	assert.Error(t, err)
+}
+
+func TestStreamConsumerHandleWithRetry(t *testing.T) {
+	errHandle := errors.New("handle failed")
+	msg := redis.XMessage{ID: "1-1"}
+
+	calls := 0
+	c := &streamConsumer{maxRetries: 2, handler: func(ctx context.Context, msg redis.XMessage) error {
+		calls++
+		if calls < 3 {
+			return errHandle
+		}
+		return nil
+	}}
+	assert.NoError(t, c.handleWithRetry(context.Background(), msg))
+	assert.Equal(t, 3, calls)
+
+	calls = 0
+	c = &streamConsumer{maxRetries: 1, handler: func(ctx context.Context, msg redis.XMessage) error {
+		calls++
+		return errHandle
+	}}
+	assert.Equal(t, errHandle, c.handleWithRetry(context.Background(), msg))
+	assert.Equal(t, 2, calls)
+
+	c = &streamConsumer{handler: func(ctx context.Context, msg redis.XMessage) error {
+		panic("boom")
+	}}
+	assert.EqualError(t, c.handleWithRetry(context.Background(), msg), "panic: boom")
+}
+
+func TestStreamConsumerRetryStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	c := &streamConsumer{maxRetries: 10, handler: func(ctx context.Context, msg redis.XMessage) error {
+		calls++
+		cancel()
+		return errors.New("handle failed")
+	}}
+
+	start := time.Now()
+	assert.Error(t, c.handleWithRetry(ctx, redis.XMessage{ID: "1-1"}))
+	assert.Equal(t, 1, calls)
+	assert.True(t, time.Since(start) < 100*time.Millisecond)
+}
+
+func TestStreamConsumerShutdown(t *testing.T) {
+	client := newTestRedis(t)
+	entered := make(chan struct{})
+	handlerErr := make(chan error, 1)
+	consumer := NewStreamConsumer(client, &StreamConsumerCfg{
+		Stream:            "test.stream",
+		Group:             "test",
+		Workers:           1,
+		BlockMs:           50,
+		MaxRetries:        -1,
+		ShutdownTimeoutMs: 100,
+	}, func(ctx context.Context, msg redis.XMessage) error {
+		close(entered)
+		<-ctx.Done()
+		handlerErr <- ctx.Err()
+		return ctx.Err()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- consumer.Run(ctx) }()
+	// the stream is created with the group, messages added before are skipped
+	for client.Exists(context.Background(), "test.stream").Val() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	_, err := AddStreamMessage(context.Background(), client, "test.stream", map[string]interface{}{"name": "a"})
+	assert.NoError(t, err)
+	<-entered
+
+	// the in-flight handler keeps running during the grace period, then its context is cancelled
+	cancel()
+	select {
+	case <-handlerErr:
+		t.Fatal("handler is cancelled before the shutdown timeout")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.NoError(t, <-done)
+	assert.Equal(t, context.Canceled, <-handlerErr)
+
+	// the failed message is left pending
+	pending, err := client.XPending(context.Background(), "test.stream", "test").Result()
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(1), pending.Count)
+	}
+}
@@ -1,15 +1,87 @@
 package container
 
 import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ory/dockertest"
 	"github.com/ory/dockertest/docker"
+	"github.com/rs/xid"
 )
 
+// labels of containers created by the builder, they identify the owning session across test runs
+const (
+	LabelSession = "gox.session"
+	LabelCreated = "gox.created"
+	LabelPID     = "gox.pid"
+	LabelHost    = "gox.host"
+	LabelReaped  = "gox.reaped" // the container is removed by the reaper of its session once the session ends
+)
+
+// ErrContainerReaped is returned when a container of the same name is owned by another session with a reaper,
+// it would be removed when that session ends, so it can not be reused
+var ErrContainerReaped = errors.New("container: container is reaped with another session")
+
 type Builder struct {
 	*dockertest.Pool
 	containerIDs map[string]bool
+	sessionID    string
+	hostname     string
+	ownersDir    string // markers of sessions on this host reusing containers of other sessions
+	reaped       bool
+}
+
+// SessionID return the id which labels every container created by the builder
+func (builder *Builder) SessionID() string {
+	return builder.sessionID
+}
+
+func (builder *Builder) labels() map[string]string {
+	labels := map[string]string{
+		LabelSession: builder.sessionID,
+		LabelCreated: strconv.FormatInt(time.Now().Unix(), 10),
+		LabelPID:     strconv.Itoa(os.Getpid()),
+		LabelHost:    builder.hostname,
+	}
+	if builder.reaped {
+		labels[LabelReaped] = "true"
+	}
+	return labels
+}
+
+// markOwner record the session as an owner of a container created by another session,
+// labels can not be changed after creation, so ReapOrphans consults the markers as well
+func (builder *Builder) markOwner(containerID string) error {
+	dir := filepath.Join(builder.ownersDir, containerID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, builder.sessionID), []byte(strconv.Itoa(os.Getpid())), 0o644)
+}
+
+// ownedByLiveSession report whether a session on this host which reused the container is still alive
+func (builder *Builder) ownedByLiveSession(containerID string) bool {
+	dir := filepath.Join(builder.ownersDir, containerID)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			continue
+		}
+		pid, err := strconv.Atoi(string(data))
+		if err == nil && processAlive(pid) {
+			return true
+		}
+	}
+	return false
 }
 
 func (builder *Builder) RemoveByID(containerID string) error {
@@ -50,14 +122,62 @@ func (builder *Builder) PruneAll() error {
 	return err
 }
 
+// ReapOrphans remove labelled containers of other sessions which are gone. A session on this host is gone
+// when its process is not alive, liveness of sessions on other hosts is unknown, so they are removed after maxAge.
+// Containers reused by name are kept while a reusing session on this host is alive.
+func (builder *Builder) ReapOrphans(maxAge time.Duration) error {
+	containers, err := builder.Client.ListContainers(docker.ListContainersOptions{
+		All: true,
+		Filters: map[string][]string{
+			"label": {LabelSession},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, container := range containers {
+		if !builder.isOrphan(container.ID, container.Labels, maxAge) {
+			continue
+		}
+		if rmErr := builder.RemoveByID(container.ID); rmErr != nil {
+			err = rmErr
+			continue
+		}
+		_ = os.RemoveAll(filepath.Join(builder.ownersDir, container.ID))
+	}
+	return err
+}
+
+func (builder *Builder) isOrphan(containerID string, labels map[string]string, maxAge time.Duration) bool {
+	if labels[LabelSession] == builder.sessionID || builder.containerIDs[containerID] {
+		return false
+	}
+	if builder.ownedByLiveSession(containerID) {
+		return false
+	}
+
+	if labels[LabelHost] == builder.hostname {
+		pid, err := strconv.Atoi(labels[LabelPID])
+		return err == nil && !processAlive(pid)
+	}
+
+	created, err := strconv.ParseInt(labels[LabelCreated], 10, 64)
+	return err == nil && time.Since(time.Unix(created, 0)) > maxAge
+}
+
 func NewConBuilder() (*Builder, error) {
 	pool, err := dockertest.NewPool("")
 	if err != nil {
 		return nil, err
 	}
+	hostname, _ := os.Hostname()
 
 	return &Builder{
 		Pool:         pool,
 		containerIDs: make(map[string]bool),
+		sessionID:    xid.New().String(),
+		hostname:     hostname,
+		ownersDir:    filepath.Join(os.TempDir(), "gox-containers"),
 	}, nil
 }
@@ -1,9 +1,17 @@
 package container
 
 import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strconv"
 	"testing"
+	"time"
 
+	"github.com/ory/dockertest/docker"
 	"github.com/stretchr/testify/assert"
+	"github.com/vx416/gox/dbprovider"
 )
 
 func TestBuildPG(t *testing.T) {
@@ -16,3 +24,69 @@ func TestBuildPG(t *testing.T) {
 	err = b.PruneAll()
 	assert.NoError(t, err)
 }
+
+func TestPublicPort(t *testing.T) {
+	container := &docker.APIContainers{
+		ID: "test",
+		Ports: []docker.APIPort{
+			{PrivatePort: 8080, PublicPort: 49153, Type: "tcp"},
+			{PrivatePort: 5432, PublicPort: 49154, Type: "tcp"},
+		},
+	}
+
+	port, err := publicPort(container, "5432/tcp")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(49154), port)
+
+	_, err = publicPort(container, "6379/tcp")
+	assert.True(t, errors.Is(err, ErrPortNotMapped))
+}
+
+func TestPortStrategy(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	port := int64(ln.Addr().(*net.TCPAddr).Port)
+	svc := &Service{Host: "127.0.0.1", Port: port}
+
+	err = ForPort().WithStartupTimeout(time.Second).WaitUntilReady(context.Background(), nil, svc)
+	assert.NoError(t, err)
+
+	assert.NoError(t, ln.Close())
+	err = ForPort().WithStartupTimeout(300*time.Millisecond).WaitUntilReady(context.Background(), nil, svc)
+	assert.True(t, errors.Is(err, ErrNotReady))
+}
+
+func TestConfigConversion(t *testing.T) {
+	db := &DB{Host: "localhost", Port: 5432, Username: "test", Password: "test", DBName: "test_db", Type: dbprovider.Pg}
+	config := db.DBConfig()
+	assert.Equal(t, "test_db", config.DBName)
+	assert.Equal(t, dbprovider.Pg, config.Type)
+	assert.Contains(t, db.DSN(), "dbname=test_db")
+
+	r := &Redis{Host: "localhost", Port: 6379}
+	assert.Equal(t, "6379", r.Config().Port)
+}
+
+func TestIsOrphan(t *testing.T) {
+	b := &Builder{sessionID: "current", hostname: "host", ownersDir: t.TempDir(), containerIDs: map[string]bool{}}
+	labels := func(session, host string, pid int, created time.Time) map[string]string {
+		return map[string]string{
+			LabelSession: session,
+			LabelHost:    host,
+			LabelPID:     strconv.Itoa(pid),
+			LabelCreated: strconv.FormatInt(created.Unix(), 10),
+		}
+	}
+	old := time.Now().Add(-2 * time.Hour)
+
+	assert.False(t, b.isOrphan("a", labels("current", "host", 1<<30, old), time.Hour))
+	assert.True(t, b.isOrphan("b", labels("other", "host", 1<<30, time.Now()), time.Hour))
+	assert.False(t, b.isOrphan("c", labels("other", "host", os.Getpid(), old), time.Hour))
+	assert.True(t, b.isOrphan("d", labels("other", "remote", os.Getpid(), old), time.Hour))
+	assert.False(t, b.isOrphan("e", labels("other", "remote", os.Getpid(), time.Now()), time.Hour))
+
+	// a container reused by a live session is kept after its creator is gone
+	reuser := &Builder{sessionID: "reuser", ownersDir: b.ownersDir}
+	assert.NoError(t, reuser.markOwner("b"))
+	assert.False(t, b.isOrphan("b", labels("other", "host", 1<<30, time.Now()), time.Hour))
+}
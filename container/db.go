@@ -1,15 +1,15 @@
 package container
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"strconv"
 
 	_ "github.com/go-sql-driver/mysql"
 
 	_ "github.com/lib/pq"
-	"github.com/ory/dockertest"
-	dc "github.com/ory/dockertest/docker"
+	"github.com/vx416/gox/dbprovider"
+	"gorm.io/gorm"
 )
 
 type DB struct {
@@ -18,146 +18,108 @@ type DB struct {
 	Username string
 	Password string
 	DBName   string
+	Type     dbprovider.DBType // filled in by RunPg and RunMysql, NewTestDatabase detects the engine when it is empty
 }
 
-func (builder *Builder) RunPg(name string, dbName string, port ...string) (*DB, error) {
-	container, err := builder.FindContainer(name)
-	if err != nil {
-		return nil, err
+// DBConfig return gox db config connecting to the database
+func (db *DB) DBConfig() *dbprovider.DBConfig {
+	return &dbprovider.DBConfig{
+		Host:     db.Host,
+		Port:     db.Port,
+		User:     db.Username,
+		Password: db.Password,
+		DBName:   db.DBName,
+		Type:     db.Type,
 	}
+}
 
-	if container != nil {
-		builder.containerIDs[container.ID] = true
-		return &DB{
-			Port:     int32(container.Ports[0].PublicPort),
-			DBName:   dbName,
-			Host:     "localhost",
-			Username: "test",
-			Password: "test",
-		}, nil
-	}
+// DSN return the connection string of the database
+func (db *DB) DSN() string {
+	return db.DBConfig().ConnString()
+}
 
-	options := &dockertest.RunOptions{
-		Repository: "postgres", Tag: "12.3-alpine", Name: name,
+// Gorm construct gorm provider connecting to the database
+func (db *DB) Gorm(gormConfig *gorm.Config) (dbprovider.GormProvider, error) {
+	return dbprovider.NewGorm(db.DBConfig(), gormConfig)
+}
+
+// PgSpec postgres service spec with test/test credentials
+func PgSpec(name string, dbName string) *ServiceSpec {
+	return &ServiceSpec{
+		Name:  name,
+		Image: "postgres",
+		Tag:   "12.3-alpine",
 		Env: []string{
 			"POSTGRES_USER=test",
 			"POSTGRES_PASSWORD=test",
 			"POSTGRES_DB=" + dbName,
 		},
+		ExposedPort: "5432/tcp",
+		Username:    "test",
+		Password:    "test",
+		WaitFor: ForSQL("postgres", func(svc *Service) string {
+			return fmt.Sprintf("postgres://%s:%s@%s/%s?sslmode=disable",
+				svc.Spec.Username, svc.Spec.Password, svc.Addr(), dbName)
+		}),
 	}
-	if len(port) == 1 {
-		options.PortBindings = make(map[dc.Port][]dc.PortBinding)
-		options.PortBindings[dc.Port("5432/tcp")] = []dc.PortBinding{
-			{
-				HostPort: port[0],
-			}}
-	}
-
-	resource, err := builder.RunWithOptions(options)
-	if err != nil {
-		return nil, err
-	}
-
-	builder.containerIDs[resource.Container.ID] = true
-
-	dbPort, err := strconv.ParseInt(resource.GetPort("5432/tcp"), 10, 64)
-	if err != nil {
-		return nil, err
-	}
-	pg := &DB{
-		Port:     int32(dbPort),
-		DBName:   dbName,
-		Host:     "localhost",
-		Username: "test",
-		Password: "test",
-	}
-
-	dsn := fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=disable",
-		pg.Username, pg.Password, pg.Host, pg.Port, pg.DBName)
-	err = builder.Retry(func() error {
-		db, err := sql.Open("postgres", dsn)
-		if err != nil {
-			fmt.Println("testing, err", err)
-			return err
-		}
-		defer db.Close()
-
-		return db.Ping()
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	return pg, nil
 }
 
-func (builder *Builder) RunMysql(name string, dbName string, port ...string) (*DB, error) {
-	container, err := builder.FindContainer(name)
-	if err != nil {
-		return nil, err
-	}
-
-	if container != nil {
-		builder.containerIDs[container.ID] = true
-		return &DB{
-			Port:     int32(container.Ports[0].PublicPort),
-			DBName:   dbName,
-			Host:     "localhost",
-			Username: "test",
-			Password: "test",
-		}, nil
-	}
-
-	options := &dockertest.RunOptions{
-		Repository: "mysql", Tag: "8", Name: name,
+// MysqlSpec mysql service spec with test/test credentials, the root password is the same as the test user
+func MysqlSpec(name string, dbName string) *ServiceSpec {
+	return &ServiceSpec{
+		Name:  name,
+		Image: "mysql",
+		Tag:   "8",
 		Env: []string{
 			"MYSQL_USER=test",
 			"MYSQL_PASSWORD=test",
 			"MYSQL_ROOT_PASSWORD=test",
 			"MYSQL_DATABASE=" + dbName,
 		},
+		ExposedPort: "3306/tcp",
+		Username:    "test",
+		Password:    "test",
+		WaitFor: ForSQL("mysql", func(svc *Service) string {
+			return fmt.Sprintf("%s:%s@tcp(%s)/%s",
+				svc.Spec.Username, svc.Spec.Password, svc.Addr(), dbName)
+		}),
 	}
+}
+
+func (builder *Builder) RunPg(name string, dbName string, port ...string) (*DB, error) {
+	return builder.runDB(PgSpec(name, dbName), dbprovider.Pg, dbName, port...)
+}
+
+func (builder *Builder) RunMysql(name string, dbName string, port ...string) (*DB, error) {
+	return builder.runDB(MysqlSpec(name, dbName), dbprovider.Mysql, dbName, port...)
+}
+
+func (builder *Builder) runDB(spec *ServiceSpec, dbType dbprovider.DBType, dbName string, port ...string) (*DB, error) {
 	if len(port) == 1 {
-		options.PortBindings = make(map[dc.Port][]dc.PortBinding)
-		options.PortBindings[dc.Port("3306/tcp")] = []dc.PortBinding{
-			{
-				HostPort: port[0],
-			}}
+		spec.HostPort = port[0]
 	}
 
-	resource, err := builder.RunWithOptions(options)
+	svc, err := builder.Run(context.Background(), spec)
 	if err != nil {
 		return nil, err
 	}
 
-	builder.containerIDs[resource.Container.ID] = true
-
-	dbPort, err := strconv.ParseInt(resource.GetPort("3306/tcp"), 10, 64)
-	if err != nil {
-		return nil, err
-	}
-	db := &DB{
-		Port:     int32(dbPort),
+	return &DB{
+		Port:     int32(svc.Port),
 		DBName:   dbName,
-		Host:     "localhost",
-		Username: "test",
-		Password: "test",
-	}
+		Host:     svc.Host,
+		Username: spec.Username,
+		Password: spec.Password,
+		Type:     dbType,
+	}, nil
+}
 
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s",
-		db.Username, db.Password, db.Host, db.Port, db.DBName)
-
-	err = builder.Retry(func() error {
-		db, err := sql.Open("mysql", dsn)
-		if err != nil {
-			return err
-		}
-		defer db.Close()
-		return db.Ping()
-	})
+func pingSQL(ctx context.Context, driver, dsn string) error {
+	db, err := sql.Open(driver, dsn)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer db.Close()
 
-	return db, nil
+	return db.PingContext(ctx)
 }
@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package container
+
+import (
+	"errors"
+	"syscall"
+)
+
+// processAlive report whether the process exists, a process owned by another user is alive as well
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
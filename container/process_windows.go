@@ -0,0 +1,27 @@
+//go:build windows
+// +build windows
+
+package container
+
+import "syscall"
+
+// stillActive exit code of a running process
+const stillActive = 259
+
+// processAlive report whether the process exists and has not exited
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	handle, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(handle)
+
+	var code uint32
+	if err := syscall.GetExitCodeProcess(handle, &code); err != nil {
+		return false
+	}
+	return code == stillActive
+}
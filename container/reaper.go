@@ -0,0 +1,96 @@
+package container
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/ory/dockertest"
+	dc "github.com/ory/dockertest/docker"
+)
+
+// ReaperSpec ryuk sidecar which removes containers of the session once its connection is closed
+func ReaperSpec() *ServiceSpec {
+	return &ServiceSpec{
+		Image:       "testcontainers/ryuk",
+		Tag:         "0.3.4",
+		ExposedPort: "8080/tcp",
+		WaitFor:     ForPort().WithStartupTimeout(30 * time.Second),
+	}
+}
+
+// StartReaper start a reaper sidecar and hold a connection to it in background until ctx is done.
+// The reaper removes containers of the session after the connection is closed,
+// so they are cleaned up even when the test binary is killed.
+// Start it before running services, containers of a reaped session can not be reused by other sessions.
+func (builder *Builder) StartReaper(ctx context.Context) error {
+	spec := ReaperSpec()
+	resource, err := builder.RunWithOptions(&dockertest.RunOptions{
+		Repository:   spec.Image,
+		Tag:          spec.Tag,
+		ExposedPorts: []string{spec.ExposedPort},
+		Mounts:       []string{"/var/run/docker.sock:/var/run/docker.sock"},
+		Labels:       map[string]string{"gox.reaper": builder.sessionID},
+	}, func(config *dc.HostConfig) {
+		config.AutoRemove = true
+	})
+	if err != nil {
+		return err
+	}
+
+	svc := &Service{ContainerID: resource.Container.ID, Host: "localhost", Spec: spec}
+	if _, err := fmt.Sscan(resource.GetPort(spec.ExposedPort), &svc.Port); err != nil {
+		return err
+	}
+	if err := spec.WaitFor.WaitUntilReady(ctx, builder, svc); err != nil {
+		return builder.notReadyErr(svc, err)
+	}
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", svc.Addr())
+	if err != nil {
+		return err
+	}
+	if err := registerSession(conn, builder.sessionID); err != nil {
+		conn.Close()
+		return err
+	}
+	builder.reaped = true
+
+	go func() {
+		defer conn.Close()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_, _ = io.Copy(ioutil.Discard, conn)
+		}()
+
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+	}()
+	return nil
+}
+
+// registerSession send the label filter of the session and wait for the acknowledgement
+func registerSession(conn net.Conn, sessionID string) error {
+	if err := conn.SetDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(conn, "label=%s=%s\n", LabelSession, sessionID); err != nil {
+		return err
+	}
+	ack, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(ack) != "ACK" {
+		return fmt.Errorf("container: unexpected reaper reply %q", ack)
+	}
+	return conn.SetDeadline(time.Time{})
+}
@@ -2,12 +2,9 @@ package container
 
 import (
 	"context"
-	"fmt"
 	"strconv"
 
-	"github.com/go-redis/redis/v8"
-	"github.com/ory/dockertest"
-	dc "github.com/ory/dockertest/docker"
+	"github.com/vx416/gox/cache"
 )
 
 type Redis struct {
@@ -15,55 +12,43 @@ type Redis struct {
 	Port int64
 }
 
-func (builder *Builder) RunRedis(name string, port ...string) (*Redis, error) {
-	container, err := builder.FindContainer(name)
-	if err != nil {
-		return nil, err
-	}
-
-	if container != nil {
-		builder.containerIDs[container.ID] = true
-		r := &Redis{
-			Host: "localhost",
-			Port: container.Ports[0].PublicPort,
-		}
-		return r, nil
-	}
-	options := &dockertest.RunOptions{Repository: "redis", Tag: "6.0.9-alpine", Name: name}
-	if len(port) == 1 {
-		options.PortBindings = make(map[dc.Port][]dc.PortBinding)
-		options.PortBindings[dc.Port("6379/tcp")] = []dc.PortBinding{
-			{
-				HostPort: port[0],
-			}}
+// Config return gox redis config connecting to the redis
+func (r *Redis) Config() *cache.RedisCfg {
+	return &cache.RedisCfg{
+		Host: r.Host,
+		Port: strconv.FormatInt(r.Port, 10),
 	}
+}
 
-	resource, err := builder.RunWithOptions(options)
-	if err != nil {
-		return nil, err
-	}
+// Client construct gox redis client connecting to the redis
+func (r *Redis) Client() (*cache.RedisClient, error) {
+	return cache.NewRedis(r.Config())
+}
 
-	builder.containerIDs[resource.Container.ID] = true
-	redisPort, err := strconv.ParseInt(resource.GetPort("6379/tcp"), 10, 64)
-	if err != nil {
-		return nil, err
+// RedisSpec redis service spec
+func RedisSpec(name string) *ServiceSpec {
+	return &ServiceSpec{
+		Name:        name,
+		Image:       "redis",
+		Tag:         "6.2-alpine", // stream consumers claim pending messages by XAUTOCLAIM of redis 6.2
+		ExposedPort: "6379/tcp",
+		WaitFor:     ForRedis(),
 	}
+}
 
-	r := &Redis{
-		Host: "localhost",
-		Port: redisPort,
+func (builder *Builder) RunRedis(name string, port ...string) (*Redis, error) {
+	spec := RedisSpec(name)
+	if len(port) == 1 {
+		spec.HostPort = port[0]
 	}
 
-	err = builder.Retry(func() error {
-		ctx := context.Background()
-		client := redis.NewClient(&redis.Options{
-			Addr: fmt.Sprintf("%s:%d", r.Host, r.Port),
-		})
-
-		return client.Ping(ctx).Err()
-	})
+	svc, err := builder.Run(context.Background(), spec)
 	if err != nil {
 		return nil, err
 	}
-	return r, nil
+
+	return &Redis{
+		Host: svc.Host,
+		Port: svc.Port,
+	}, nil
 }
@@ -0,0 +1,127 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ory/dockertest"
+	dc "github.com/ory/dockertest/docker"
+)
+
+// ErrPortNotMapped is returned when the exposed port of a container is not published on the host
+var ErrPortNotMapped = errors.New("container: exposed port is not mapped")
+
+// ServiceSpec describe a service container, containers with the same name are reused
+type ServiceSpec struct {
+	Name        string
+	Image       string
+	Tag         string
+	Env         []string
+	ExposedPort string // container port with protocol, e.g. 5432/tcp
+	HostPort    string // bind the exposed port to a fixed host port, random port if empty
+	Username    string
+	Password    string
+	WaitFor     WaitStrategy // readiness strategy, the service is returned as soon as it is running if nil
+}
+
+// Service running service container
+type Service struct {
+	ContainerID string
+	Host        string
+	Port        int64
+	Spec        *ServiceSpec
+}
+
+// Addr return host:port of the service
+func (svc *Service) Addr() string {
+	return fmt.Sprintf("%s:%d", svc.Host, svc.Port)
+}
+
+// Run find the container of spec or start a new one, then wait until the service is ready.
+// The error of a service which is not ready contains the last log lines of its container.
+func (builder *Builder) Run(ctx context.Context, spec *ServiceSpec) (*Service, error) {
+	svc, err := builder.runContainer(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	if spec.WaitFor != nil {
+		if err := spec.WaitFor.WaitUntilReady(ctx, builder, svc); err != nil {
+			return nil, builder.notReadyErr(svc, err)
+		}
+	}
+	return svc, nil
+}
+
+func (builder *Builder) runContainer(spec *ServiceSpec) (*Service, error) {
+	container, err := builder.FindContainer(spec.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if container != nil {
+		if container.Labels[LabelSession] != builder.sessionID {
+			if container.Labels[LabelReaped] != "" {
+				return nil, fmt.Errorf("%w: %s", ErrContainerReaped, spec.Name)
+			}
+			if err := builder.markOwner(container.ID); err != nil {
+				return nil, err
+			}
+		}
+		builder.containerIDs[container.ID] = true
+		port, err := publicPort(container, spec.ExposedPort)
+		if err != nil {
+			return nil, err
+		}
+		return &Service{ContainerID: container.ID, Host: "localhost", Port: port, Spec: spec}, nil
+	}
+
+	options := &dockertest.RunOptions{
+		Repository:   spec.Image,
+		Tag:          spec.Tag,
+		Name:         spec.Name,
+		Env:          spec.Env,
+		ExposedPorts: []string{spec.ExposedPort},
+		Labels:       builder.labels(),
+	}
+	if spec.HostPort != "" {
+		options.PortBindings = map[dc.Port][]dc.PortBinding{
+			dc.Port(spec.ExposedPort): {{HostPort: spec.HostPort}},
+		}
+	}
+
+	resource, err := builder.RunWithOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	builder.containerIDs[resource.Container.ID] = true
+	port, err := strconv.ParseInt(resource.GetPort(spec.ExposedPort), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &Service{ContainerID: resource.Container.ID, Host: "localhost", Port: port, Spec: spec}, nil
+}
+
+// publicPort return the host port which the exposed port is mapped to
+func publicPort(container *dc.APIContainers, exposedPort string) (int64, error) {
+	portType := "tcp"
+	portStr := exposedPort
+	if i := strings.Index(exposedPort, "/"); i >= 0 {
+		portStr, portType = exposedPort[:i], exposedPort[i+1:]
+	}
+	privatePort, err := strconv.ParseInt(portStr, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, port := range container.Ports {
+		if port.PrivatePort == privatePort && port.Type == portType && port.PublicPort != 0 {
+			return port.PublicPort, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %s of %s", ErrPortNotMapped, exposedPort, container.ID)
+}
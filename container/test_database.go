@@ -0,0 +1,207 @@
+package container
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rs/xid"
+	"github.com/vx416/gox/dbprovider"
+)
+
+// maxDBNameLen postgres truncates identifiers longer than 63 bytes, mysql allows 64 characters
+const maxDBNameLen = 63
+
+// NewTestDatabase create an isolated database cloned from db for the test, and drop it when the test finishes.
+// db should already be migrated, and on postgres it must have no open connections while being cloned.
+// Mysql databases are cloned table by table as root, views and foreign keys are not copied.
+func (builder *Builder) NewTestDatabase(t testing.TB, db *DB) *dbprovider.DBConfig {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	name := testDatabaseName(db.DBName)
+	dbType := db.Type
+	var err error
+	if dbType == "" {
+		dbType, err = detectDBType(ctx, db)
+		if err != nil {
+			t.Fatalf("detect database type of %s failed: %+v", db.DBName, err)
+		}
+	}
+	switch dbType {
+	case dbprovider.Pg:
+		err = clonePg(ctx, db, name)
+	case dbprovider.Mysql:
+		err = cloneMysql(ctx, db, name)
+	default:
+		err = fmt.Errorf("container: clone %q database is not supported", dbType)
+	}
+	if err != nil {
+		t.Fatalf("create test database %s failed: %+v", name, err)
+	}
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		var err error
+		switch dbType {
+		case dbprovider.Pg:
+			err = dropPg(ctx, db, name)
+		case dbprovider.Mysql:
+			err = dropMysql(ctx, db, name)
+		}
+		if err != nil {
+			t.Errorf("drop test database %s failed: %+v", name, err)
+		}
+	})
+
+	config := db.DBConfig()
+	config.DBName = name
+	config.Type = dbType
+	return config
+}
+
+// detectDBType probe postgres then mysql, the probe does not wait for the database to be ready
+func detectDBType(ctx context.Context, db *DB) (dbprovider.DBType, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	admin, err := pgAdmin(db)
+	if err != nil {
+		return "", err
+	}
+	err = admin.PingContext(ctx)
+	admin.Close()
+	if err == nil {
+		return dbprovider.Pg, nil
+	}
+
+	root, err := mysqlRoot(db)
+	if err != nil {
+		return "", err
+	}
+	defer root.Close()
+	if err := root.PingContext(ctx); err != nil {
+		return "", fmt.Errorf("container: database is neither postgres nor mysql: %w", err)
+	}
+	return dbprovider.Mysql, nil
+}
+
+// testDatabaseName suffix base with a unique id, base is truncated so that the name fits identifier limits
+func testDatabaseName(base string) string {
+	suffix := "_" + xid.New().String()
+	if len(base)+len(suffix) > maxDBNameLen {
+		base = base[:maxDBNameLen-len(suffix)]
+		for len(base) > 0 && !utf8.ValidString(base) {
+			base = base[:len(base)-1]
+		}
+	}
+	return base + suffix
+}
+
+// pgAdmin open the postgres maintenance database, templates can not be cloned while connected to them
+func pgAdmin(db *DB) (*sql.DB, error) {
+	dsn := fmt.Sprintf("postgres://%s:%s@%s:%d/postgres?sslmode=disable",
+		db.Username, db.Password, db.Host, db.Port)
+	return sql.Open("postgres", dsn)
+}
+
+func clonePg(ctx context.Context, db *DB, name string) error {
+	admin, err := pgAdmin(db)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+
+	_, err = admin.ExecContext(ctx, fmt.Sprintf(`CREATE DATABASE "%s" TEMPLATE "%s"`, name, db.DBName))
+	return err
+}
+
+func dropPg(ctx context.Context, db *DB, name string) error {
+	admin, err := pgAdmin(db)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+
+	_, err = admin.ExecContext(ctx, `SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE datname = $1`, name)
+	if err != nil {
+		return err
+	}
+	_, err = admin.ExecContext(ctx, fmt.Sprintf(`DROP DATABASE IF EXISTS "%s"`, name))
+	return err
+}
+
+// mysqlRoot open mysql as root, the root password of MysqlSpec is the same as the test user
+func mysqlRoot(db *DB) (*sql.DB, error) {
+	dsn := fmt.Sprintf("root:%s@tcp(%s:%d)/", db.Password, db.Host, db.Port)
+	return sql.Open("mysql", dsn)
+}
+
+func cloneMysql(ctx context.Context, db *DB, name string) error {
+	root, err := mysqlRoot(db)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	conn, err := root.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	rows, err := conn.QueryContext(ctx,
+		`SELECT table_name FROM information_schema.tables WHERE table_schema = ? AND table_type = 'BASE TABLE'`, db.DBName)
+	if err != nil {
+		return err
+	}
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			rows.Close()
+			return err
+		}
+		tables = append(tables, table)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	stmts := []string{
+		fmt.Sprintf("CREATE DATABASE `%s`", name),
+		fmt.Sprintf("GRANT ALL ON `%s`.* TO '%s'@'%%'", name, db.Username),
+		"SET FOREIGN_KEY_CHECKS = 0",
+	}
+	for _, table := range tables {
+		stmts = append(stmts,
+			fmt.Sprintf("CREATE TABLE `%s`.`%s` LIKE `%s`.`%s`", name, table, db.DBName, table),
+			fmt.Sprintf("INSERT INTO `%s`.`%s` SELECT * FROM `%s`.`%s`", name, table, db.DBName, table),
+		)
+	}
+	for _, stmt := range stmts {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dropMysql(ctx context.Context, db *DB, name string) error {
+	root, err := mysqlRoot(db)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	_, err = root.ExecContext(ctx, fmt.Sprintf("DROP DATABASE IF EXISTS `%s`", name))
+	return err
+}
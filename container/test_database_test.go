@@ -0,0 +1,75 @@
+package container
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vx416/gox/dbprovider"
+)
+
+func TestTestDatabaseName(t *testing.T) {
+	name := testDatabaseName("test_db")
+	assert.True(t, strings.HasPrefix(name, "test_db_"))
+	assert.NotEqual(t, name, testDatabaseName("test_db"))
+
+	// long names are truncated before the unique suffix, on a character boundary
+	name = testDatabaseName(strings.Repeat("a", 100))
+	assert.Len(t, name, maxDBNameLen)
+	assert.True(t, strings.HasPrefix(name, strings.Repeat("a", 42)+"_"))
+	name = testDatabaseName("a" + strings.Repeat("資料", 20))
+	assert.True(t, len(name) <= maxDBNameLen)
+	assert.True(t, utf8.ValidString(name))
+}
+
+func TestNewTestDatabase(t *testing.T) {
+	b, err := NewConBuilder()
+	if err == nil {
+		err = b.Client.Ping()
+	}
+	if err != nil {
+		t.Skipf("docker is not available: %v", err)
+	}
+	defer b.PruneAll()
+
+	db, err := b.RunPg("gox_test_database", "test_db")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, dbprovider.Pg, db.Type)
+	template, err := sql.Open("postgres", db.DSN())
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = template.Exec(`CREATE TABLE items (id INT PRIMARY KEY)`)
+	assert.NoError(t, err)
+	// the template can not be cloned while connected
+	assert.NoError(t, template.Close())
+
+	var name string
+	t.Run("clone", func(t *testing.T) {
+		config := b.NewTestDatabase(t, db)
+		name = config.DBName
+		assert.NotEqual(t, db.DBName, name)
+
+		clone, err := sql.Open("postgres", config.ConnString())
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer clone.Close()
+		_, err = clone.Exec(`INSERT INTO items (id) VALUES (1)`)
+		assert.NoError(t, err)
+	})
+
+	// the database is dropped when the test finishes
+	admin, err := pgAdmin(db)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer admin.Close()
+	var exists bool
+	assert.NoError(t, admin.QueryRow(`SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = $1)`, name).Scan(&exists))
+	assert.False(t, exists)
+}
@@ -0,0 +1,233 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	dc "github.com/ory/dockertest/docker"
+)
+
+const (
+	defaultStartupTimeout = time.Minute
+	waitPollInterval      = 200 * time.Millisecond
+	logTailLines          = "20"
+)
+
+// ErrNotReady is returned when a service does not become ready before its startup timeout
+var ErrNotReady = errors.New("container: service is not ready")
+
+// WaitStrategy block until the service is ready, it gives up when ctx is done
+type WaitStrategy interface {
+	WaitUntilReady(ctx context.Context, builder *Builder, svc *Service) error
+}
+
+// startupTimeout deadline of a wait strategy, the deadline of ctx is kept if it is earlier
+type startupTimeout struct {
+	timeout time.Duration
+}
+
+func (t startupTimeout) poll(ctx context.Context, check func(ctx context.Context) error) error {
+	timeout := t.timeout
+	if timeout <= 0 {
+		timeout = defaultStartupTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(waitPollInterval)
+	defer ticker.Stop()
+	for {
+		err := check(ctx)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %v", ErrNotReady, err)
+		case <-ticker.C:
+		}
+	}
+}
+
+// PortStrategy wait until the exposed port accepts tcp connections
+type PortStrategy struct {
+	startupTimeout
+}
+
+// ForPort construct a tcp port wait strategy
+func ForPort() *PortStrategy {
+	return &PortStrategy{}
+}
+
+// WithStartupTimeout set the deadline of waiting
+func (s *PortStrategy) WithStartupTimeout(timeout time.Duration) *PortStrategy {
+	s.timeout = timeout
+	return s
+}
+
+func (s *PortStrategy) WaitUntilReady(ctx context.Context, builder *Builder, svc *Service) error {
+	return s.poll(ctx, func(ctx context.Context) error {
+		conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", svc.Addr())
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	})
+}
+
+// LogStrategy wait until the container log matches a pattern
+type LogStrategy struct {
+	startupTimeout
+	pattern    *regexp.Regexp
+	occurrence int
+}
+
+// ForLog construct a log wait strategy, it panics if pattern is not a valid regexp
+func ForLog(pattern string) *LogStrategy {
+	return &LogStrategy{pattern: regexp.MustCompile(pattern), occurrence: 1}
+}
+
+// WithOccurrence wait until the pattern matches n times, e.g. postgres logs readiness twice during initialization
+func (s *LogStrategy) WithOccurrence(n int) *LogStrategy {
+	s.occurrence = n
+	return s
+}
+
+// WithStartupTimeout set the deadline of waiting
+func (s *LogStrategy) WithStartupTimeout(timeout time.Duration) *LogStrategy {
+	s.timeout = timeout
+	return s
+}
+
+func (s *LogStrategy) WaitUntilReady(ctx context.Context, builder *Builder, svc *Service) error {
+	return s.poll(ctx, func(ctx context.Context) error {
+		logs, err := builder.containerLogs(ctx, svc.ContainerID, "all")
+		if err != nil {
+			return err
+		}
+		if n := len(s.pattern.FindAllIndex(logs, -1)); n < s.occurrence {
+			return fmt.Errorf("log %q matched %d of %d times", s.pattern, n, s.occurrence)
+		}
+		return nil
+	})
+}
+
+// HTTPStrategy wait until an http endpoint of the service responds 200
+type HTTPStrategy struct {
+	startupTimeout
+	path string
+}
+
+// ForHTTP construct an http wait strategy
+func ForHTTP(path string) *HTTPStrategy {
+	return &HTTPStrategy{path: path}
+}
+
+// WithStartupTimeout set the deadline of waiting
+func (s *HTTPStrategy) WithStartupTimeout(timeout time.Duration) *HTTPStrategy {
+	s.timeout = timeout
+	return s
+}
+
+func (s *HTTPStrategy) WaitUntilReady(ctx context.Context, builder *Builder, svc *Service) error {
+	url := "http://" + svc.Addr() + s.path
+	return s.poll(ctx, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s responds %d", url, resp.StatusCode)
+		}
+		return nil
+	})
+}
+
+// SQLStrategy wait until the database accepts ping
+type SQLStrategy struct {
+	startupTimeout
+	driver string
+	dsn    func(svc *Service) string
+}
+
+// ForSQL construct a sql ping wait strategy, dsn build the data source name from the running service
+func ForSQL(driver string, dsn func(svc *Service) string) *SQLStrategy {
+	return &SQLStrategy{driver: driver, dsn: dsn}
+}
+
+// WithStartupTimeout set the deadline of waiting
+func (s *SQLStrategy) WithStartupTimeout(timeout time.Duration) *SQLStrategy {
+	s.timeout = timeout
+	return s
+}
+
+func (s *SQLStrategy) WaitUntilReady(ctx context.Context, builder *Builder, svc *Service) error {
+	dsn := s.dsn(svc)
+	return s.poll(ctx, func(ctx context.Context) error {
+		return pingSQL(ctx, s.driver, dsn)
+	})
+}
+
+// RedisStrategy wait until redis accepts ping
+type RedisStrategy struct {
+	startupTimeout
+}
+
+// ForRedis construct a redis ping wait strategy
+func ForRedis() *RedisStrategy {
+	return &RedisStrategy{}
+}
+
+// WithStartupTimeout set the deadline of waiting
+func (s *RedisStrategy) WithStartupTimeout(timeout time.Duration) *RedisStrategy {
+	s.timeout = timeout
+	return s
+}
+
+func (s *RedisStrategy) WaitUntilReady(ctx context.Context, builder *Builder, svc *Service) error {
+	client := redis.NewClient(&redis.Options{Addr: svc.Addr(), MaxRetries: -1})
+	defer client.Close()
+
+	return s.poll(ctx, func(ctx context.Context) error {
+		return client.Ping(ctx).Err()
+	})
+}
+
+// containerLogs return stdout and stderr of the container, tail is the number of last lines or all
+func (builder *Builder) containerLogs(ctx context.Context, containerID string, tail string) ([]byte, error) {
+	var buf bytes.Buffer
+	err := builder.Client.Logs(dc.LogsOptions{
+		Context:      ctx,
+		Container:    containerID,
+		OutputStream: &buf,
+		ErrorStream:  &buf,
+		Stdout:       true,
+		Stderr:       true,
+		Tail:         tail,
+	})
+	return buf.Bytes(), err
+}
+
+// notReadyErr attach the last log lines of the container to the wait error
+func (builder *Builder) notReadyErr(svc *Service, err error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	logs, logErr := builder.containerLogs(ctx, svc.ContainerID, logTailLines)
+	if logErr != nil {
+		return fmt.Errorf("container %s: %w (read logs failed: %v)", svc.Spec.Name, err, logErr)
+	}
+	return fmt.Errorf("container %s: %w\nlast %s log lines:\n%s", svc.Spec.Name, err, logTailLines, logs)
+}
@@ -0,0 +1,52 @@
+package dbprovider
+
+import (
+	"context"
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrStaleFencingToken is returned when a newer lock holder already wrote the resource
+var ErrStaleFencingToken = errors.New("fencing token is stale")
+
+// FencingToken record the highest fencing token accepted for a resource,
+// the table should be migrated before using CheckFencingToken
+type FencingToken struct {
+	Resource string `gorm:"primaryKey;size:191"`
+	Token    int64
+}
+
+// TableName implement gorm tabler interface
+func (FencingToken) TableName() string {
+	return "fencing_tokens"
+}
+
+// CheckFencingToken reject the write if a newer token has been accepted for resource, otherwise record the token.
+// It must be called inside transaction, the token row stays locked until the transaction ends.
+func CheckFencingToken(txCtx context.Context, db GormProvider, resource string, token int64) error {
+	if _, ok := txCtx.Value(TxKey{}).(*gorm.DB); !ok {
+		return ErrNilTx
+	}
+	tx := db.GetDB(txCtx)
+
+	err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&FencingToken{Resource: resource}).Error
+	if err != nil {
+		return err
+	}
+
+	current := &FencingToken{}
+	err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("resource = ?", resource).Take(current).Error
+	if err != nil {
+		return err
+	}
+	if current.Token > token {
+		return ErrStaleFencingToken
+	}
+	if current.Token == token {
+		return nil
+	}
+
+	return tx.Model(current).Where("resource = ?", resource).Update("token", token).Error
+}
@@ -0,0 +1,36 @@
+package interceptor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vx416/gox/cache"
+	"github.com/vx416/gox/container"
+)
+
+// newTestRedis run a redis container for the test, the test is skipped when docker is not available
+func newTestRedis(t *testing.T) *cache.RedisClient {
+	t.Helper()
+	builder, err := container.NewConBuilder()
+	if err == nil {
+		err = builder.Client.Ping()
+	}
+	if err != nil {
+		t.Skipf("docker is not available: %v", err)
+	}
+
+	redis, err := builder.RunRedis("gox_interceptor_test")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { _ = builder.PruneAll() })
+
+	client, err := redis.Client()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	assert.NoError(t, client.FlushAll(context.Background()).Err())
+	return client
+}
@@ -1,5 +1,7 @@
 package interceptor
 
 const (
-	RequestIDKey = "X-Request-ID"
+	RequestIDKey     = "X-Request-ID"
+	AuthorizationKey = "authorization"
+	RetryAfterKey    = "retry-after"
 )
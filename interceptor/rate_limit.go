@@ -0,0 +1,82 @@
+package interceptor
+
+import (
+	"context"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/vx416/gox/cache"
+	"github.com/vx416/gox/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// RateLimit reject requests over the limit with codes.ResourceExhausted and retry-after header.
+// Requests are let through when the limiter itself fails or keyFunc returns an empty key,
+// so unidentified clients do not throttle each other in a shared bucket.
+func RateLimit(limiter cache.RateLimiter, keyFunc func(ctx context.Context, info *grpc.UnaryServerInfo) string) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (resp interface{}, err error) {
+		key := keyFunc(ctx, info)
+		if key == "" {
+			return handler(ctx, req)
+		}
+		result, err := limiter.Allow(ctx, key)
+		if err != nil {
+			log.Ctx(ctx).Err(err).Warn("rate limit failed")
+			return handler(ctx, req)
+		}
+
+		if !result.Allowed {
+			retryAfter := int(math.Ceil(result.RetryAfter.Seconds()))
+			_ = grpc.SetHeader(ctx, metadata.Pairs(RetryAfterKey, strconv.Itoa(retryAfter)))
+			return nil, status.Errorf(codes.ResourceExhausted, "rate limit exceeded, retry after %ds", retryAfter)
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// KeyByPeer rate limit key by client ip, it is empty when the peer is unknown
+func KeyByPeer(ctx context.Context, info *grpc.UnaryServerInfo) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// KeyByJWTSubject rate limit key by subject of bearer token in authorization metadata, fallback to client ip
+func KeyByJWTSubject(verifyFunc func(token *jwt.Token) (interface{}, error)) func(ctx context.Context, info *grpc.UnaryServerInfo) string {
+	return func(ctx context.Context, info *grpc.UnaryServerInfo) string {
+		md, _ := metadata.FromIncomingContext(ctx)
+		vals := md.Get(AuthorizationKey)
+		if len(vals) == 0 || !strings.HasPrefix(vals[0], "Bearer ") {
+			return KeyByPeer(ctx, info)
+		}
+
+		claims := jwt.MapClaims{}
+		token, err := jwt.ParseWithClaims(strings.TrimPrefix(vals[0], "Bearer "), claims, verifyFunc)
+		if err != nil || !token.Valid {
+			return KeyByPeer(ctx, info)
+		}
+		if sub, ok := claims["sub"].(string); ok && sub != "" {
+			return sub
+		}
+		return KeyByPeer(ctx, info)
+	}
+}
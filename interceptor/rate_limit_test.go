@@ -0,0 +1,43 @@
+package interceptor
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vx416/gox/cache"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestRateLimit(t *testing.T) {
+	client := newTestRedis(t)
+	limiter := cache.NewSlidingWindowLimiter(client, "test", 1, time.Minute)
+	interceptor := RateLimit(limiter, KeyByPeer)
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+	calls := 0
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		calls++
+		return "ok", nil
+	}
+
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}})
+	resp, err := interceptor(ctx, nil, info, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", resp)
+
+	_, err = interceptor(ctx, nil, info, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, 1, calls)
+
+	// requests without a peer are not limited in a shared bucket
+	for i := 0; i < 2; i++ {
+		_, err = interceptor(context.Background(), nil, info, handler)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 3, calls)
+}
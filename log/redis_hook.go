@@ -0,0 +1,117 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm/logger"
+)
+
+type redisStartKey struct{}
+
+// RedisHookConfig redis hook config, levels follow gorm logger semantics.
+// redis.Nil is a normal result of reads, such commands are logged like successful ones.
+type RedisHookConfig struct {
+	LogLevel      logger.LogLevel
+	SlowThreshold time.Duration
+	MaxArgLen     int // arguments longer than it are truncated, default is 64
+}
+
+type redisHook struct {
+	logLevel      logger.LogLevel
+	slowThreshold time.Duration
+	maxArgLen     int
+}
+
+// NewRedisHook for go-redis log use logger in context, add it by client.AddHook
+func NewRedisHook(config RedisHookConfig) redis.Hook {
+	if config.MaxArgLen <= 0 {
+		config.MaxArgLen = 64
+	}
+	return &redisHook{
+		logLevel:      config.LogLevel,
+		slowThreshold: config.SlowThreshold,
+		maxArgLen:     config.MaxArgLen,
+	}
+}
+
+// BeforeProcess ...
+func (h *redisHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisStartKey{}, time.Now()), nil
+}
+
+// AfterProcess ...
+func (h *redisHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	h.trace(ctx, cmd.Err(), func() string { return h.cmdString(cmd) })
+	return nil
+}
+
+// BeforeProcessPipeline ...
+func (h *redisHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisStartKey{}, time.Now()), nil
+}
+
+// AfterProcessPipeline ...
+func (h *redisHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if cmdErr := cmd.Err(); cmdErr != nil && !errors.Is(cmdErr, redis.Nil) {
+			err = cmdErr
+			break
+		}
+	}
+
+	h.trace(ctx, err, func() string {
+		lines := make([]string, len(cmds))
+		for i, cmd := range cmds {
+			lines[i] = h.cmdString(cmd)
+		}
+		return "pipeline: " + strings.Join(lines, "; ")
+	})
+	return nil
+}
+
+func (h *redisHook) trace(ctx context.Context, err error, fc func() string) {
+	if h.logLevel <= logger.Silent {
+		return
+	}
+
+	var elapsed time.Duration
+	if begin, ok := ctx.Value(redisStartKey{}).(time.Time); ok {
+		elapsed = time.Since(begin)
+	}
+	currentLogger := Ctx(ctx).Field("redis_log", true).Field("elapsed_ms", float64(elapsed.Nanoseconds())/1e6)
+
+	switch {
+	case err != nil && !errors.Is(err, redis.Nil):
+		currentLogger.Err(err).Error(fc())
+	case elapsed > h.slowThreshold && h.slowThreshold != 0 && h.logLevel >= logger.Warn:
+		currentLogger.Warnf("slow redis command >= %v: %s", h.slowThreshold, fc())
+	case h.logLevel >= logger.Info:
+		currentLogger.Info(fc())
+	}
+}
+
+// cmdString format command with truncated arguments, credentials of auth commands are redacted
+func (h *redisHook) cmdString(cmd redis.Cmder) string {
+	args := cmd.Args()
+	parts := make([]string, len(args))
+	redact := cmd.Name() == "auth" || cmd.Name() == "hello"
+
+	for i, arg := range args {
+		s := fmt.Sprint(arg)
+		switch {
+		case i == 0:
+		case redact:
+			s = "***"
+		case len(s) > h.maxArgLen:
+			s = fmt.Sprintf("%s...(%d bytes)", s[:h.maxArgLen], len(s))
+		}
+		parts[i] = s
+	}
+	return strings.Join(parts, " ")
+}
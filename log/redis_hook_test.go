@@ -0,0 +1,113 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"gorm.io/gorm/logger"
+)
+
+func TestRedisHookCmdString(t *testing.T) {
+	hook := NewRedisHook(RedisHookConfig{LogLevel: logger.Info, MaxArgLen: 4}).(*redisHook)
+	ctx := context.Background()
+
+	set := redis.NewStatusCmd(ctx, "set", "key", strings.Repeat("v", 10))
+	assert.Equal(t, "set key vvvv...(10 bytes)", hook.cmdString(set))
+
+	auth := redis.NewStatusCmd(ctx, "auth", "user", "secret")
+	assert.Equal(t, "auth *** ***", hook.cmdString(auth))
+
+	ctx, err := hook.BeforeProcess(ctx, set)
+	assert.NoError(t, err)
+	assert.NoError(t, hook.AfterProcess(ctx, set))
+}
+
+// traceLevels run cmd through the hook and return levels of the logged entries
+func traceLevels(config RedisHookConfig, cmd redis.Cmder, elapsed time.Duration) []zapcore.Level {
+	core, logs := observer.New(zapcore.DebugLevel)
+	ctx := Attach(context.Background(), &ZapAdapter{zaplog: zap.New(core)})
+	hook := NewRedisHook(config)
+
+	ctx, _ = hook.BeforeProcess(ctx, cmd)
+	ctx = context.WithValue(ctx, redisStartKey{}, time.Now().Add(-elapsed))
+	_ = hook.AfterProcess(ctx, cmd)
+
+	levels := make([]zapcore.Level, 0, logs.Len())
+	for _, entry := range logs.All() {
+		levels = append(levels, entry.Level)
+	}
+	return levels
+}
+
+func TestRedisHookLevels(t *testing.T) {
+	ctx := context.Background()
+	ok := redis.NewStringCmd(ctx, "get", "key")
+	missed := redis.NewStringCmd(ctx, "get", "key")
+	missed.SetErr(redis.Nil)
+	failed := redis.NewStringCmd(ctx, "get", "key")
+	failed.SetErr(errors.New("connection refused"))
+
+	tests := []struct {
+		level  logger.LogLevel
+		cmd    redis.Cmder
+		levels []zapcore.Level
+	}{
+		{logger.Silent, failed, []zapcore.Level{}},
+		{logger.Error, failed, []zapcore.Level{zapcore.ErrorLevel}},
+		{logger.Error, missed, []zapcore.Level{}},
+		{logger.Error, ok, []zapcore.Level{}},
+		{logger.Info, failed, []zapcore.Level{zapcore.ErrorLevel}},
+		{logger.Info, missed, []zapcore.Level{zapcore.InfoLevel}},
+		{logger.Info, ok, []zapcore.Level{zapcore.InfoLevel}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.levels, traceLevels(RedisHookConfig{LogLevel: tt.level}, tt.cmd, 0), "level %d cmd %v", tt.level, tt.cmd.Err())
+	}
+}
+
+func TestRedisHookSlowThreshold(t *testing.T) {
+	ctx := context.Background()
+	cmd := redis.NewStringCmd(ctx, "get", "key")
+	config := RedisHookConfig{LogLevel: logger.Warn, SlowThreshold: 100 * time.Millisecond}
+
+	assert.Equal(t, []zapcore.Level{}, traceLevels(config, cmd, 10*time.Millisecond))
+	assert.Equal(t, []zapcore.Level{zapcore.WarnLevel}, traceLevels(config, cmd, 200*time.Millisecond))
+
+	// misses are slow like any other command
+	cmd.SetErr(redis.Nil)
+	assert.Equal(t, []zapcore.Level{zapcore.WarnLevel}, traceLevels(config, cmd, 200*time.Millisecond))
+
+	config.LogLevel = logger.Error
+	assert.Equal(t, []zapcore.Level{}, traceLevels(config, cmd, 200*time.Millisecond))
+	config.SlowThreshold = 0
+	config.LogLevel = logger.Warn
+	assert.Equal(t, []zapcore.Level{}, traceLevels(config, cmd, 200*time.Millisecond))
+}
+
+func TestRedisHookPipeline(t *testing.T) {
+	ctx := context.Background()
+	missed := redis.NewStringCmd(ctx, "get", "a")
+	missed.SetErr(redis.Nil)
+	failed := redis.NewStringCmd(ctx, "get", "b")
+	failed.SetErr(errors.New("connection refused"))
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	ctx = Attach(ctx, &ZapAdapter{zaplog: zap.New(core)})
+	hook := NewRedisHook(RedisHookConfig{LogLevel: logger.Error})
+
+	_ = hook.AfterProcessPipeline(ctx, []redis.Cmder{missed, missed})
+	assert.Equal(t, 0, logs.Len())
+	_ = hook.AfterProcessPipeline(ctx, []redis.Cmder{missed, failed})
+	if assert.Equal(t, 1, logs.Len()) {
+		assert.Equal(t, zapcore.ErrorLevel, logs.All()[0].Level)
+		assert.Equal(t, "pipeline: get a; get b", logs.All()[0].Message)
+	}
+}
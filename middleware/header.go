@@ -1,6 +1,13 @@
 package middleware
 
 const (
-	HeaderXRequestID = "X-Request-ID"
-	HeaderAuth       = "Authentication"
+	HeaderXRequestID         = "X-Request-ID"
+	HeaderAuth               = "Authentication"
+	HeaderXForwardedFor      = "X-Forwarded-For"
+	HeaderRetryAfter         = "Retry-After"
+	HeaderRateLimitLimit     = "X-RateLimit-Limit"
+	HeaderRateLimitRemaining = "X-RateLimit-Remaining"
+	HeaderRateLimitReset     = "X-RateLimit-Reset"
+	HeaderIdempotencyKey     = "Idempotency-Key"
+	HeaderIdempotentReplayed = "Idempotent-Replayed"
 )
@@ -0,0 +1,191 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/vx416/gox/cache"
+	"github.com/vx416/gox/log"
+	"github.com/vx416/gox/resperr"
+)
+
+const (
+	// idempotencyStoreTimeout bound saving responses and releasing keys, they must not fail when the client is gone
+	idempotencyStoreTimeout = 5 * time.Second
+	// idempotencyMaxBody bound request bodies which are read into memory for fingerprinting
+	idempotencyMaxBody = 1 << 20
+)
+
+type (
+	// recordResponseWriter record the response written by the handler, headers set before
+	// the handler runs, e.g. by outer middlewares, are not recorded
+	recordResponseWriter struct {
+		http.ResponseWriter
+		status int
+		body   bytes.Buffer
+		before http.Header
+		header http.Header
+	}
+)
+
+// Idempotency replay the recorded response for requests carrying the same Idempotency-Key header.
+// A concurrent request with the same key gets 409, a reused key with a different request gets 422.
+// Server errors are not recorded, so clients can retry them. Request bodies larger than 1MB get 413.
+// Keys are scoped by scopeFunc, e.g. KeyByJWTSubject, so callers can not replay responses of each other.
+func Idempotency(store cache.IdempotencyStore, scopeFunc func(req *http.Request) string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			key := req.Header.Get(HeaderIdempotencyKey)
+			if key == "" || !isUnsafeMethod(req.Method) {
+				next.ServeHTTP(resp, req)
+				return
+			}
+			key = scopeFunc(req) + ":" + key
+
+			ctx := req.Context()
+			reqBody, err := ioutil.ReadAll(io.LimitReader(req.Body, idempotencyMaxBody+1))
+			if err != nil {
+				writeErr(resp, resperr.NewRespErr(http.StatusBadRequest))
+				return
+			}
+			if len(reqBody) > idempotencyMaxBody {
+				writeErr(resp, resperr.NewRespErr(http.StatusRequestEntityTooLarge))
+				return
+			}
+			req.Body = ioutil.NopCloser(bytes.NewBuffer(reqBody))
+			fingerprint := requestFingerprint(req, reqBody)
+
+			if replayed := replayRecorded(resp, req, store, key, fingerprint); replayed {
+				return
+			}
+
+			lock, err := store.Lock(ctx, key)
+			if errors.Is(err, cache.ErrNotObtained) {
+				writeErr(resp, resperr.NewRespErr(http.StatusConflict, "request with the same idempotency key is in progress"))
+				return
+			}
+			if err != nil {
+				writeErr(resp, err)
+				return
+			}
+			// the response must be recorded even if the client disconnected, otherwise its retry runs the handler again
+			storeCtx := log.Ctx(ctx).Attach(context.Background())
+			defer func() {
+				releaseCtx, cancel := context.WithTimeout(storeCtx, idempotencyStoreTimeout)
+				defer cancel()
+				_ = lock.Release(releaseCtx)
+			}()
+
+			// the previous holder may have finished between the lookup and the lock
+			if replayed := replayRecorded(resp, req, store, key, fingerprint); replayed {
+				return
+			}
+
+			watchCtx, cancel := cache.WithWatchdog(ctx, lock, 0)
+			defer cancel()
+			req = req.WithContext(watchCtx)
+
+			w := &recordResponseWriter{ResponseWriter: resp, status: http.StatusOK, before: resp.Header().Clone()}
+			next.ServeHTTP(w, req)
+			w.recordHeader()
+
+			if w.status >= http.StatusInternalServerError {
+				return
+			}
+			saveCtx, saveCancel := context.WithTimeout(storeCtx, idempotencyStoreTimeout)
+			defer saveCancel()
+			err = store.Save(saveCtx, key, &cache.IdempotentResponse{
+				Fingerprint: fingerprint,
+				Status:      w.status,
+				Header:      w.header,
+				Body:        w.body.Bytes(),
+			})
+			if err != nil {
+				log.Ctx(ctx).Err(err).Error("save idempotent response failed")
+			}
+		})
+	}
+}
+
+// replayRecorded write the recorded response, it returns false if no response is recorded
+func replayRecorded(resp http.ResponseWriter, req *http.Request, store cache.IdempotencyStore, key, fingerprint string) bool {
+	ctx := req.Context()
+	recorded, err := store.Get(ctx, key)
+	if errors.Is(err, cache.ErrCacheMiss) {
+		return false
+	}
+	if err != nil {
+		writeErr(resp, err)
+		return true
+	}
+
+	if recorded.Fingerprint != fingerprint {
+		writeErr(resp, resperr.NewRespErr(http.StatusUnprocessableEntity, "idempotency key is reused with a different request"))
+		return true
+	}
+
+	for k, vals := range recorded.Header {
+		resp.Header()[k] = vals
+	}
+	resp.Header().Set(HeaderIdempotentReplayed, "true")
+	resp.WriteHeader(recorded.Status)
+	_, _ = resp.Write(recorded.Body)
+	return true
+}
+
+func requestFingerprint(req *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(req.Method))
+	h.Write([]byte(req.URL.RequestURI()))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func isUnsafeMethod(method string) bool {
+	return method == http.MethodPost || method == http.MethodPut ||
+		method == http.MethodPatch || method == http.MethodDelete
+}
+
+func (w *recordResponseWriter) WriteHeader(code int) {
+	w.recordHeader()
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recordResponseWriter) Write(b []byte) (int, error) {
+	w.recordHeader()
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// recordHeader keep headers changed by the handler, headers are sent with the first write
+func (w *recordResponseWriter) recordHeader() {
+	if w.header != nil {
+		return
+	}
+	w.header = make(http.Header)
+	for k, vals := range w.ResponseWriter.Header() {
+		if !equalValues(w.before[k], vals) {
+			w.header[k] = append([]string(nil), vals...)
+		}
+	}
+}
+
+func equalValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
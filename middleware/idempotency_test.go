@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vx416/gox/cache"
+)
+
+func newIdempotencyHandler(t *testing.T, handler http.HandlerFunc) (http.Handler, cache.IdempotencyStore) {
+	store, err := cache.NewIdempotencyStore(newTestRedis(t), &cache.IdempotencyCfg{Prefix: "test"})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	scope := func(req *http.Request) string { return "user" }
+	idempotent := Idempotency(store, scope)(handler)
+
+	// outer middleware headers must not be recorded
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set(HeaderXRequestID, req.Header.Get(HeaderXRequestID))
+		idempotent.ServeHTTP(resp, req)
+	}), store
+}
+
+func serveIdempotent(handler http.Handler, key, requestID, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(body))
+	req.Header.Set(HeaderIdempotencyKey, key)
+	req.Header.Set(HeaderXRequestID, requestID)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	return resp
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	var calls int32
+	handler, store := newIdempotencyHandler(t, func(resp http.ResponseWriter, req *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		body, _ := ioutil.ReadAll(req.Body)
+		resp.Header().Set("Location", "/orders/1")
+		resp.WriteHeader(http.StatusCreated)
+		_, _ = resp.Write(append(body, byte('0'+n)))
+	})
+
+	resp := serveIdempotent(handler, "key", "req-1", "order")
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	assert.Equal(t, "order1", resp.Body.String())
+	assert.Empty(t, resp.Header().Get(HeaderIdempotentReplayed))
+
+	recorded, err := store.Get(context.Background(), "user:key")
+	assert.NoError(t, err)
+	assert.Equal(t, http.Header{"Location": {"/orders/1"}}, recorded.Header)
+
+	resp = serveIdempotent(handler, "key", "req-2", "order")
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	assert.Equal(t, "order1", resp.Body.String())
+	assert.Equal(t, "true", resp.Header().Get(HeaderIdempotentReplayed))
+	assert.Equal(t, "/orders/1", resp.Header().Get("Location"))
+	assert.Equal(t, "req-2", resp.Header().Get(HeaderXRequestID))
+	assert.Equal(t, int32(1), calls)
+
+	// key reused with a different body
+	resp = serveIdempotent(handler, "key", "req-3", "other")
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+	assert.Equal(t, int32(1), calls)
+
+	resp = serveIdempotent(handler, "", "req-4", "order")
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	assert.Equal(t, int32(2), calls)
+}
+
+func TestIdempotencyInFlight(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	handler, _ := newIdempotencyHandler(t, func(resp http.ResponseWriter, req *http.Request) {
+		close(entered)
+		<-release
+		resp.WriteHeader(http.StatusAccepted)
+	})
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- serveIdempotent(handler, "key", "req-1", "order") }()
+	<-entered
+
+	resp := serveIdempotent(handler, "key", "req-2", "order")
+	assert.Equal(t, http.StatusConflict, resp.Code)
+
+	close(release)
+	assert.Equal(t, http.StatusAccepted, (<-first).Code)
+	resp = serveIdempotent(handler, "key", "req-3", "order")
+	assert.Equal(t, http.StatusAccepted, resp.Code)
+	assert.Equal(t, "true", resp.Header().Get(HeaderIdempotentReplayed))
+}
+
+func TestIdempotencyServerError(t *testing.T) {
+	var calls int32
+	handler, _ := newIdempotencyHandler(t, func(resp http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		resp.WriteHeader(http.StatusInternalServerError)
+	})
+
+	// server errors are not recorded, so retries run the handler again
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusInternalServerError, serveIdempotent(handler, "key", "req", "order").Code)
+	}
+	assert.Equal(t, int32(2), calls)
+}
+
+func TestIdempotencyBodyLimit(t *testing.T) {
+	handler, _ := newIdempotencyHandler(t, func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusCreated)
+	})
+
+	resp := serveIdempotent(handler, "key", "req", strings.Repeat("a", idempotencyMaxBody+1))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.Code)
+	resp = serveIdempotent(handler, "key", "req", strings.Repeat("a", idempotencyMaxBody))
+	assert.Equal(t, http.StatusCreated, resp.Code)
+}
+
+func TestNewIdempotencyStoreWithoutLocker(t *testing.T) {
+	_, err := cache.NewIdempotencyStore(&cache.RedisClient{}, nil)
+	assert.Equal(t, cache.ErrNoLocker, err)
+}
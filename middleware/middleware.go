@@ -1 +1,16 @@
 package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/vx416/gox/resperr"
+)
+
+// writeErr write error as resperr json response
+func writeErr(resp http.ResponseWriter, err error) {
+	errResp := resperr.ToErrResponse(err)
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(errResp.HTTPStatus)
+	_ = json.NewEncoder(resp).Encode(errResp)
+}
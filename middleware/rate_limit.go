@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/vx416/gox/cache"
+	"github.com/vx416/gox/log"
+	"github.com/vx416/gox/resperr"
+)
+
+// RateLimit reject requests over the limit with 429 and Retry-After header.
+// Requests are let through when the limiter itself fails or keyFunc returns an empty key,
+// so unidentified clients do not throttle each other in a shared bucket.
+func RateLimit(limiter cache.RateLimiter, keyFunc func(req *http.Request) string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			ctx := req.Context()
+			key := keyFunc(req)
+			if key == "" {
+				next.ServeHTTP(resp, req)
+				return
+			}
+			result, err := limiter.Allow(ctx, key)
+			if err != nil {
+				log.Ctx(ctx).Err(err).Warn("rate limit failed")
+				next.ServeHTTP(resp, req)
+				return
+			}
+
+			resp.Header().Set(HeaderRateLimitLimit, strconv.Itoa(result.Limit))
+			resp.Header().Set(HeaderRateLimitRemaining, strconv.Itoa(result.Remaining))
+			resp.Header().Set(HeaderRateLimitReset, strconv.Itoa(int(math.Ceil(result.ResetAfter.Seconds()))))
+			if !result.Allowed {
+				retryAfter := int(math.Ceil(result.RetryAfter.Seconds()))
+				resp.Header().Set(HeaderRetryAfter, strconv.Itoa(retryAfter))
+				writeErr(resp, resperr.NewRespErr(http.StatusTooManyRequests))
+				return
+			}
+
+			next.ServeHTTP(resp, req)
+		})
+	}
+}
+
+// KeyByIP rate limit key by the peer address, X-Forwarded-For is ignored since any client can set it.
+// Use KeyByForwardedIP behind reverse proxies.
+func KeyByIP(req *http.Request) string {
+	return remoteIP(req)
+}
+
+// KeyByForwardedIP rate limit key by client ip from X-Forwarded-For, which is only trusted when the peer is
+// one of the trusted proxy networks. The rightmost address not belonging to a trusted proxy is the client.
+func KeyByForwardedIP(trustedCIDRs ...string) (func(req *http.Request) string, error) {
+	trusted := make([]*net.IPNet, 0, len(trustedCIDRs))
+	for _, cidr := range trustedCIDRs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		trusted = append(trusted, ipNet)
+	}
+	isTrusted := func(addr string) bool {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return false
+		}
+		for _, ipNet := range trusted {
+			if ipNet.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(req *http.Request) string {
+		client := remoteIP(req)
+		if !isTrusted(client) {
+			return client
+		}
+
+		hops := strings.Split(req.Header.Get(HeaderXForwardedFor), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if hop == "" {
+				continue
+			}
+			client = hop
+			if !isTrusted(hop) {
+				break
+			}
+		}
+		return client
+	}, nil
+}
+
+func remoteIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// KeyByJWTSubject rate limit key by subject of token verified by JWTToken middleware, fallback to client ip
+func KeyByJWTSubject(req *http.Request) string {
+	token, ok := req.Context().Value(JWTTokenKey{}).(*jwt.Token)
+	if !ok {
+		return KeyByIP(req)
+	}
+
+	switch claims := token.Claims.(type) {
+	case jwt.MapClaims:
+		if sub, ok := claims["sub"].(string); ok && sub != "" {
+			return sub
+		}
+	case *jwt.StandardClaims:
+		if claims.Subject != "" {
+			return claims.Subject
+		}
+	}
+	return KeyByIP(req)
+}
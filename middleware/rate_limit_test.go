@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vx416/gox/cache"
+)
+
+func TestRateLimit(t *testing.T) {
+	client := newTestRedis(t)
+	limiter := cache.NewSlidingWindowLimiter(client, "test", 2, time.Minute)
+	handler := RateLimit(limiter, KeyByIP)(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusOK)
+	}))
+	serve := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	for _, remaining := range []string{"1", "0"} {
+		resp := serve("10.0.0.1:1234")
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, "2", resp.Header().Get(HeaderRateLimitLimit))
+		assert.Equal(t, remaining, resp.Header().Get(HeaderRateLimitRemaining))
+		assert.Equal(t, "60", resp.Header().Get(HeaderRateLimitReset))
+	}
+
+	resp := serve("10.0.0.1:4321")
+	assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+	assert.Equal(t, "0", resp.Header().Get(HeaderRateLimitRemaining))
+	assert.Equal(t, "60", resp.Header().Get(HeaderRetryAfter))
+
+	assert.Equal(t, http.StatusOK, serve("10.0.0.2:1234").Code)
+
+	// requests without a key are not limited in a shared bucket
+	for i := 0; i < 3; i++ {
+		resp := serve("")
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Empty(t, resp.Header().Get(HeaderRateLimitLimit))
+	}
+}
+
+func TestKeyByForwardedIP(t *testing.T) {
+	keyFunc, err := KeyByForwardedIP("10.0.0.0/8")
+	assert.NoError(t, err)
+	key := func(remoteAddr, forwardedFor string) string {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set(HeaderXForwardedFor, forwardedFor)
+		return keyFunc(req)
+	}
+
+	assert.Equal(t, "1.2.3.4", key("1.2.3.4:80", "5.6.7.8"))
+	assert.Equal(t, "5.6.7.8", key("10.0.0.1:80", "5.6.7.8"))
+	assert.Equal(t, "5.6.7.8", key("10.0.0.1:80", "9.9.9.9, 5.6.7.8, 10.0.0.2"))
+	assert.Equal(t, "10.0.0.1", key("10.0.0.1:80", ""))
+
+	_, err = KeyByForwardedIP("10.0.0.0")
+	assert.Error(t, err)
+}